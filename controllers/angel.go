@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"context"
 	"encoding/xml"
 	"time"
 
+	"strconv"
 	"strings"
 
 	"fmt"
@@ -12,6 +14,11 @@ import (
 	"github.com/xzdbd/ops-angel/models"
 )
 
+const (
+	// lines of logs returned when dc service NAME logs has no count
+	defaultLogLines = 20
+)
+
 type AngelController struct {
 	beego.Controller
 }
@@ -33,6 +40,29 @@ func (c *AngelController) Post() {
 	var req models.Request
 	xml.Unmarshal(c.Ctx.Input.RequestBody, &req)
 	beego.Info("User request, User:", req.FromUserName, "Message Type:", req.MsgType, "Content:", req.Content)
+	models.ResumeCommuteWatches(req.FromUserName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), models.ReplyDeadline)
+	defer cancel()
+	reply, ok := models.RunBeforeDeadline(ctx, req.FromUserName, func(ctx context.Context) interface{} {
+		return handleRequest(ctx, req)
+	})
+	if !ok {
+		reply = textReplyHandler(req, models.ProcessingMsg)
+	} else if !models.FitsReply(reply) {
+		// every part is pushed, so they arrive in order, and the passive
+		// reply is left empty
+		go models.PushLater(req.FromUserName, reply)
+		c.Ctx.WriteString("success")
+		return
+	}
+	c.Data["xml"] = reply
+	c.ServeXML()
+}
+
+// handleRequest runs the tool requested by the message and returns the
+// passive reply. The backend calls of the tools stop when ctx is done.
+func handleRequest(ctx context.Context, req models.Request) interface{} {
 	if req.MsgType == models.MsgTypeText {
 		content := req.Content
 		toolname := strings.Split(content, " ")[0]
@@ -40,68 +70,140 @@ func (c *AngelController) Post() {
 		switch toolname {
 		case models.GoogleToolName, models.GoogleToolAlias:
 			beego.Info("User request google tool, User:", req.FromUserName, "Command:", req.Content)
-			resp, respHelp := googleToolHandler(content, req)
+			resp, respHelp := googleToolHandler(ctx, content, req)
 			if respHelp.Content != "" {
 				beego.Info("Response to the user with google tool help. User:", req.FromUserName)
-				c.Data["xml"] = respHelp
-				c.ServeXML()
-				break
+				return respHelp
 			}
 			beego.Info("Response to the user with google result. User:", req.FromUserName, "Count:", resp.ArticleCount)
-			c.Data["xml"] = resp
-			c.ServeXML()
+			return resp
+
+		case models.SearchToolName, models.SearchToolAlias:
+			beego.Info("User request search tool, User:", req.FromUserName, "Command:", req.Content)
+			resp, respHelp := searchToolHandler(ctx, content, req)
+			if respHelp.Content != "" {
+				beego.Info("Response to the user with search tool help. User:", req.FromUserName)
+				return respHelp
+			}
+			beego.Info("Response to the user with search result. User:", req.FromUserName, "Count:", resp.ArticleCount)
+			return resp
+
+		case models.KbToolName:
+			beego.Info("User request kb tool, User:", req.FromUserName, "Command:", req.Content)
+			resp, respText := kbToolHandler(content, req)
+			if respText.Content != "" {
+				return respText
+			}
+			beego.Info("Response to the user with kb result. User:", req.FromUserName, "Count:", resp.ArticleCount)
+			return resp
 
 		case models.DockerCloudToolName, models.DockerCloudToolAlias:
 			beego.Info("User request dockercloud tool, User:", req.FromUserName, "Command:", req.Content)
-			resp := dockerCloudToolHandler(content, req)
+			resp := dockerCloudToolHandler(ctx, content, req)
 			beego.Info("Response to the user with dockercloud result. User:", req.FromUserName, "content:", req.Content)
-			c.Data["xml"] = resp
-			c.ServeXML()
+			return resp
+
+		case models.K8sToolName, models.K8sToolAlias:
+			beego.Info("User request kubernetes tool, User:", req.FromUserName, "Command:", req.Content)
+			resp := k8sToolHandler(ctx, content, req)
+			beego.Info("Response to the user with kubernetes result. User:", req.FromUserName, "content:", req.Content)
+			return resp
+
+		case models.EnvToolName:
+			beego.Info("User request env tool, User:", req.FromUserName, "Command:", req.Content)
+			return envToolHandler(content, req)
+
+		case models.StatusToolName:
+			beego.Info("User request status tool, User:", req.FromUserName, "Command:", req.Content)
+			return statusToolHandler(req)
+
+		case models.MapRouteCommand:
+			beego.Info("User request map route, User:", req.FromUserName, "Command:", req.Content)
+			return mapRouteHandler(content, req)
+
+		case models.MapPickCommand:
+			beego.Info("User request map pick, User:", req.FromUserName, "Command:", req.Content)
+			return mapPickHandler(ctx, content, req)
 
 		case models.MapToolName, models.MapToolAlias:
 			beego.Info("User request map tool, User:", req.FromUserName, "Command:", req.Content)
-			resp := mapToolHandler(content, req)
+			if fields := strings.Fields(content); len(fields) > 1 && fields[1] == "nearby" {
+				resp, respText := mapNearbyHandler(ctx, content, req)
+				if respText.Content != "" {
+					return respText
+				}
+				beego.Info("Response to the user with nearby places. User:", req.FromUserName, "Count:", resp.ArticleCount)
+				return resp
+			}
+			resp := mapToolHandler(ctx, content, req)
 			beego.Info("Response to the user with map result. User:", req.FromUserName, "content:", req.Content)
-			c.Data["xml"] = resp
-			c.ServeXML()
+			return resp
 
 		default:
-			c.Data["xml"] = descriptionHandler(req)
-			c.ServeXML()
+			if destination, ok := models.FromHereDestination(req.FromUserName, content); ok {
+				beego.Info("User request directions from the current location, User:", req.FromUserName, "Destination:", destination)
+				return mapFromHereHandler(ctx, destination, req)
+			}
+			return descriptionHandler(req)
 		}
 	} else if req.MsgType == models.MsgTypeLocation {
 		resp := mapToolLocationHandler(req)
-		c.Data["xml"] = resp
-		c.ServeXML()
+		return resp
 	} else if req.MsgType == models.MsgTypeEvent && req.Event == models.MsgTypeEventSubscribe {
-		c.Data["xml"] = subscribeHandler(req)
-		c.ServeXML()
+		return subscribeHandler(req)
 	} else {
-		c.Data["xml"] = descriptionHandler(req)
-		c.ServeXML()
+		return descriptionHandler(req)
 	}
-
 }
 
-func googleToolHandler(content string, req models.Request) (models.NewsResponse, models.TextResponse) {
+func googleToolHandler(ctx context.Context, content string, req models.Request) (models.NewsResponse, models.TextResponse) {
 	var googleTool models.GoogleTool
-	var resp models.NewsResponse
 
 	googleTool.NewTool()
 
-	cmd := strings.SplitN(content, " ", 2)
-	length := len(cmd)
+	return runSearchTool(ctx, content, req, &googleTool.SearchTool)
+}
 
-	if length == 2 {
-		googleTool.Key = cmd[1]
-		googleTool.N = 4
+func searchToolHandler(ctx context.Context, content string, req models.Request) (models.NewsResponse, models.TextResponse) {
+	var searchTool models.SearchTool
+
+	searchTool.NewTool()
+
+	return runSearchTool(ctx, content, req, &searchTool)
+}
+
+// runSearchTool parses the options shared by google and search and runs the
+// search.
+func runSearchTool(ctx context.Context, content string, req models.Request, searchTool *models.SearchTool) (models.NewsResponse, models.TextResponse) {
+	var resp models.NewsResponse
+
+	searchTool.UserID = req.FromUserName
+	searchTool.SetContext(ctx)
+	options, args := parseOptions(strings.Split(content, " ")[1:], "n", "site", "lang", "provider")
+	if len(args) == 1 && args[0] == "next" && len(options) == 0 { // g next
+		searchTool.Next = true
+	} else if len(args) > 0 { // g [--n N] [--site SITE] [--lang LANG] KEY
+		searchTool.Key = strings.Join(args, " ")
+		searchTool.N = models.SearchDefaultN
+		if options["n"] != "" {
+			n, err := strconv.Atoi(options["n"])
+			if err != nil || n < 1 {
+				return resp, searchToolHelpHandler(req, *searchTool)
+			}
+			searchTool.N = n
+		}
+		if options["provider"] != "" {
+			searchTool.Provider = options["provider"]
+		}
+		searchTool.Site = options["site"]
+		searchTool.Lang = options["lang"]
 	} else {
-		return resp, googleToolHelpHandler(req, googleTool)
+		return resp, searchToolHelpHandler(req, *searchTool)
 	}
 
-	resp, err := googleTool.Run()
+	resp, err := searchTool.Run()
 	if err != nil {
-		return resp, googleToolHelpHandler(req, googleTool)
+		return resp, textReplyHandler(req, models.ErrorMessage(err))
 	}
 	resp.ToUserName = req.FromUserName
 	resp.FromUserName = req.ToUserName
@@ -109,23 +211,56 @@ func googleToolHandler(content string, req models.Request) (models.NewsResponse,
 	return resp, models.TextResponse{}
 }
 
-func googleToolHelpHandler(req models.Request, g models.GoogleTool) models.TextResponse {
+func searchToolHelpHandler(req models.Request, s models.SearchTool) models.TextResponse {
 	var respHelp models.TextResponse
 	respHelp.ToUserName = req.FromUserName
 	respHelp.FromUserName = req.ToUserName
-	respHelp.Content = g.HelpMsg
+	respHelp.Content = s.HelpMsg
 	respHelp.CreateTime = time.Duration(time.Now().Unix())
 	respHelp.MsgType = models.MsgTypeText
 	return respHelp
 }
 
-func dockerCloudToolHandler(content string, req models.Request) models.TextResponse {
+func kbToolHandler(content string, req models.Request) (models.NewsResponse, models.TextResponse) {
+	var kbTool models.KbTool
+	var resp models.NewsResponse
+
+	kbTool.NewTool()
+
+	cmd := strings.SplitN(content, " ", 2)
+	if len(cmd) < 2 || strings.TrimSpace(cmd[1]) == "" {
+		return resp, textReplyHandler(req, kbTool.HelpMsg)
+	}
+	kbTool.Key = cmd[1]
+
+	resp, err := kbTool.Run()
+	if err != nil {
+		return resp, textReplyHandler(req, models.ErrorMessage(err))
+	}
+	resp.ToUserName = req.FromUserName
+	resp.FromUserName = req.ToUserName
+	resp.CreateTime = time.Duration(time.Now().Unix())
+	return resp, models.TextResponse{}
+}
+
+func dockerCloudToolHandler(ctx context.Context, content string, req models.Request) models.TextResponse {
 	var dcTool models.DockerCloudTool
 	var resp models.TextResponse
 
 	dcTool.NewTool()
+	dcTool.UserID = req.FromUserName
+	dcTool.SetContext(ctx)
 
 	cmd := strings.Split(content, " ")
+	options, args := parseOptions(cmd[1:], "e", "env", "state", "sort")
+	env, err := models.UserEnvironment(req.FromUserName, envOption(options))
+	if err != nil {
+		return textReplyHandler(req, err.Error())
+	}
+	dcTool.Env = env
+	dcTool.StateFilter = options["state"]
+	dcTool.SortBy = options["sort"]
+	cmd = append(cmd[:1], args...)
 	length := len(cmd)
 
 	if length == 2 {
@@ -139,12 +274,15 @@ func dockerCloudToolHandler(content string, req models.Request) models.TextRespo
 		if cmd[1] == "service" {
 			dcTool.Action = "status"
 			dcTool.ServiceName = cmd[2]
+		} else if (cmd[1] == "stack" || cmd[1] == "node") && cmd[2] == "list" {
+			dcTool.Object = cmd[1]
+			dcTool.Action = cmd[2]
 		} else {
 			return dockerCloudToolHelpHandler(req, dcTool)
 		}
 	} else if length == 4 {
 		if cmd[1] == "service" {
-			if cmd[3] == "start" || cmd[3] == "stop" || cmd[3] == "redeploy" {
+			if cmd[3] == "start" || cmd[3] == "stop" || cmd[3] == "restart" || cmd[3] == "redeploy" {
 				dcTool.Action = cmd[3]
 				var valid bool
 				valid, resp = validatePrivilegedAction(req)
@@ -153,21 +291,74 @@ func dockerCloudToolHandler(content string, req models.Request) models.TextRespo
 				}
 			} else if cmd[3] == "status" {
 				dcTool.Action = cmd[3]
+			} else if cmd[3] == "logs" {
+				dcTool.Action = cmd[3]
+				dcTool.Lines = defaultLogLines
 			} else {
 				return dockerCloudToolHelpHandler(req, dcTool)
 			}
 			dcTool.ServiceName = cmd[2]
+		} else if cmd[1] == "container" {
+			if cmd[2] == "list" {
+				dcTool.Action = "containers"
+				dcTool.ServiceName = cmd[3]
+			} else if cmd[2] == "inspect" {
+				dcTool.Action = "inspect"
+				dcTool.ContainerName = cmd[3]
+			} else {
+				return dockerCloudToolHelpHandler(req, dcTool)
+			}
+		} else if cmd[1] == "stack" {
+			if cmd[2] == "start" || cmd[2] == "stop" || cmd[2] == "redeploy" {
+				var valid bool
+				valid, resp = validatePrivilegedAction(req)
+				if !valid {
+					return resp
+				}
+			} else if cmd[2] != "status" {
+				return dockerCloudToolHelpHandler(req, dcTool)
+			}
+			dcTool.Object = cmd[1]
+			dcTool.Action = cmd[2]
+			dcTool.StackName = cmd[3]
+		} else if cmd[1] == "node" && cmd[2] == "status" {
+			dcTool.Object = cmd[1]
+			dcTool.Action = cmd[2]
+			dcTool.NodeName = cmd[3]
 		} else {
 			return dockerCloudToolHelpHandler(req, dcTool)
 		}
+	} else if length == 5 && cmd[1] == "service" { // dc service NAME scale N, dc service NAME logs N
+		n, err := strconv.Atoi(cmd[4])
+		if err != nil || n < 0 {
+			return dockerCloudToolHelpHandler(req, dcTool)
+		}
+		if cmd[3] == "scale" {
+			var valid bool
+			valid, resp = validatePrivilegedAction(req)
+			if !valid {
+				return resp
+			}
+			dcTool.Replicas = n
+		} else if cmd[3] == "logs" {
+			dcTool.Lines = n
+		} else {
+			return dockerCloudToolHelpHandler(req, dcTool)
+		}
+		dcTool.Action = cmd[3]
+		dcTool.ServiceName = cmd[2]
 	}
 
-	resp, err := dcTool.Run()
+	resp, err = dcTool.Run()
 	if err != nil {
+		if msg := models.BackendErrorMessage(err); msg != "" {
+			return textReplyHandler(req, env.ReplyPrefix()+msg)
+		}
 		return dockerCloudToolHelpHandler(req, dcTool)
 	}
 	resp.ToUserName = req.FromUserName
 	resp.FromUserName = req.ToUserName
+	resp.Content = env.ReplyPrefix() + resp.Content
 	resp.CreateTime = time.Duration(time.Now().Unix())
 	return resp
 }
@@ -182,19 +373,147 @@ func dockerCloudToolHelpHandler(req models.Request, dc models.DockerCloudTool) m
 	return respHelp
 }
 
-func mapToolHandler(content string, req models.Request) models.TextResponse {
+func k8sToolHandler(ctx context.Context, content string, req models.Request) models.TextResponse {
+	var k8sTool models.K8sTool
+	var resp models.TextResponse
+
+	options, cmd := parseOptions(strings.Split(content, " ")[1:], "e", "env", "ns", "n")
+	env, err := models.UserEnvironment(req.FromUserName, envOption(options))
+	if err != nil {
+		return textReplyHandler(req, err.Error())
+	}
+
+	k8sTool.NewTool(env)
+	k8sTool.SetContext(ctx)
+	if options["ns"] != "" {
+		k8sTool.Namespace = options["ns"]
+	} else if options["n"] != "" {
+		k8sTool.Namespace = options["n"]
+	}
+	length := len(cmd)
+
+	if length == 1 && (cmd[0] == "deploy" || cmd[0] == "pods") { // k8s deploy, k8s pods
+		k8sTool.Action = cmd[0]
+	} else if length == 2 && (cmd[0] == "pods" || cmd[0] == "status" || cmd[0] == "restart" || cmd[0] == "logs") {
+		if cmd[0] == "restart" {
+			var valid bool
+			valid, resp = validatePrivilegedAction(req)
+			if !valid {
+				return resp
+			}
+		}
+		k8sTool.Action = cmd[0]
+		k8sTool.Name = cmd[1]
+		k8sTool.Lines = defaultLogLines
+	} else if length == 3 && (cmd[0] == "scale" || cmd[0] == "logs") { // k8s scale NAME N, k8s logs POD N
+		n, err := strconv.Atoi(cmd[2])
+		if err != nil || n < 0 {
+			return k8sToolHelpHandler(req, k8sTool)
+		}
+		if cmd[0] == "scale" {
+			var valid bool
+			valid, resp = validatePrivilegedAction(req)
+			if !valid {
+				return resp
+			}
+		}
+		k8sTool.Action = cmd[0]
+		k8sTool.Name = cmd[1]
+		k8sTool.Replicas = int32(n)
+		k8sTool.Lines = int64(n)
+	} else {
+		return k8sToolHelpHandler(req, k8sTool)
+	}
+
+	resp, err = k8sTool.Run()
+	if err != nil {
+		return k8sToolHelpHandler(req, k8sTool)
+	}
+	resp.ToUserName = req.FromUserName
+	resp.FromUserName = req.ToUserName
+	resp.Content = env.ReplyPrefix() + resp.Content
+	resp.CreateTime = time.Duration(time.Now().Unix())
+	return resp
+}
+
+func k8sToolHelpHandler(req models.Request, k models.K8sTool) models.TextResponse {
+	var respHelp models.TextResponse
+	respHelp.ToUserName = req.FromUserName
+	respHelp.FromUserName = req.ToUserName
+	respHelp.Content = k.HelpMsg
+	respHelp.CreateTime = time.Duration(time.Now().Unix())
+	respHelp.MsgType = models.MsgTypeText
+	return respHelp
+}
+
+func envToolHandler(content string, req models.Request) models.TextResponse {
+	var envTool models.EnvTool
+
+	envTool.NewTool(req)
+
+	cmd := strings.Split(content, " ")
+	length := len(cmd)
+
+	if length == 1 || (length == 2 && cmd[1] == "list") { // env, env list
+		envTool.Action = "list"
+	} else if length == 3 && cmd[1] == "use" { // env use NAME
+		envTool.Action = "use"
+		envTool.EnvName = cmd[2]
+	} else {
+		return textReplyHandler(req, envTool.HelpMsg)
+	}
+
+	resp, err := envTool.Run()
+	if err != nil {
+		return textReplyHandler(req, envTool.HelpMsg)
+	}
+	resp.ToUserName = req.FromUserName
+	resp.FromUserName = req.ToUserName
+	resp.CreateTime = time.Duration(time.Now().Unix())
+	return resp
+}
+
+// envOption returns the environment picked by -e or --env.
+func envOption(options map[string]string) string {
+	if options["e"] != "" {
+		return options["e"]
+	}
+	return options["env"]
+}
+
+func statusToolHandler(req models.Request) models.TextResponse {
+	var statusTool models.StatusTool
+
+	statusTool.NewTool()
+
+	resp, err := statusTool.Run()
+	if err != nil {
+		return textReplyHandler(req, statusTool.HelpMsg)
+	}
+	resp.ToUserName = req.FromUserName
+	resp.FromUserName = req.ToUserName
+	resp.CreateTime = time.Duration(time.Now().Unix())
+	return resp
+}
+
+func mapToolHandler(ctx context.Context, content string, req models.Request) interface{} {
 	var mapTool models.MapTool
 	var resp models.TextResponse
 
 	mapTool.NewTool(req)
+	mapTool.SetContext(ctx)
 
-	cmd := strings.Split(content, " ")
+	options, cmd := parseOptions(mergeTimeOptions(strings.Split(content, " ")), "mode", "depart", "arrive", "reply", "from")
 	length := len(cmd)
+	mapTool.Mode = options["mode"]
+	mapTool.Depart = options["depart"]
+	mapTool.Arrive = options["arrive"]
+	mapTool.Reply = options["reply"]
 
-	if length == 5 && cmd[1] == "direct" && cmd[3] == "to" { // map direct A to B
+	if length == 5 && cmd[1] == "direct" && cmd[3] == "to" { // map direct A to B [--mode MODE] [--depart TIME | --arrive TIME] [--reply STYLE]
 		mapTool.Origin = cmd[2]
 		mapTool.Destination = cmd[4]
-		resp = mapTool.Directions()
+		return mapReply(mapTool.RouteReply((*models.MapTool).Directions), req)
 	} else if length == 4 && cmd[1] == "set" && cmd[2] == "home" { //map set home A
 		mapTool.HomeAddress = cmd[3]
 		resp = mapTool.SetHome()
@@ -202,7 +521,47 @@ func mapToolHandler(content string, req models.Request) models.TextResponse {
 		resp = mapTool.GetHome()
 	} else if length == 4 && cmd[1] == "go" && cmd[2] == "home" { //map go home A
 		mapTool.Origin = cmd[3]
-		resp = mapTool.GoHome()
+		return mapReply(mapTool.RouteReply((*models.MapTool).GoHome), req)
+	} else if (length == 3 || length == 4) && cmd[1] == "go" { //map go NAME [A]
+		mapTool.PlaceName = cmd[2]
+		if length == 4 {
+			mapTool.Origin = cmd[3]
+		}
+		return mapReply(mapTool.RouteReply((*models.MapTool).GoTo), req)
+	} else if length == 4 && cmd[1] == "save" { //map save NAME A
+		mapTool.PlaceName = cmd[2]
+		mapTool.Place = cmd[3]
+		resp = mapTool.SavePlace()
+	} else if (length == 4 || length == 5) && cmd[1] == "watch" { //map watch PLACE HH:MM [DAYS] [--from PLACE]
+		mapTool.Destination = cmd[2]
+		mapTool.Depart = cmd[3]
+		if length == 5 {
+			mapTool.Days = cmd[4]
+		}
+		mapTool.Origin = options["from"]
+		resp = mapTool.Watch()
+	} else if length == 2 && cmd[1] == "watches" { //map watches
+		resp = mapTool.Watches()
+	} else if length == 3 && cmd[1] == "unwatch" { //map unwatch PLACE|N
+		mapTool.Destination = cmd[2]
+		resp = mapTool.Unwatch()
+	} else if length == 2 && cmd[1] == "places" { //map places
+		resp = mapTool.Places()
+	} else if length == 3 && cmd[1] == "delete" { //map delete NAME
+		mapTool.PlaceName = cmd[2]
+		resp = mapTool.DeletePlace()
+	} else if length == 4 && cmd[1] == "set" && cmd[2] == "mode" { //map set mode MODE
+		mapTool.Mode = cmd[3]
+		resp = mapTool.SetMode()
+	} else if length == 4 && cmd[1] == "set" && cmd[2] == "region" { //map set region REGION
+		mapTool.Region = cmd[3]
+		resp = mapTool.SetRegion()
+	} else if length == 4 && cmd[1] == "set" && cmd[2] == "timezone" { //map set timezone TIMEZONE
+		mapTool.Timezone = cmd[3]
+		resp = mapTool.SetTimezone()
+	} else if length == 4 && cmd[1] == "set" && cmd[2] == "reply" { //map set reply STYLE
+		mapTool.Reply = cmd[3]
+		resp = mapTool.SetReply()
 	} else {
 		resp = mapToolHelpHandler(req, mapTool)
 	}
@@ -213,6 +572,112 @@ func mapToolHandler(content string, req models.Request) models.TextResponse {
 	return resp
 }
 
+func mapRouteHandler(content string, req models.Request) models.TextResponse {
+	var mapTool models.MapTool
+	var resp models.TextResponse
+
+	mapTool.NewTool(req)
+
+	cmd := strings.Split(content, " ")
+	if len(cmd) != 2 { // route N
+		return mapToolHelpHandler(req, mapTool)
+	}
+	n, err := strconv.Atoi(cmd[1])
+	if err != nil {
+		return mapToolHelpHandler(req, mapTool)
+	}
+	mapTool.Route = n
+	resp = mapTool.ShowRoute()
+
+	resp.ToUserName = req.FromUserName
+	resp.FromUserName = req.ToUserName
+	resp.CreateTime = time.Duration(time.Now().Unix())
+	return resp
+}
+
+// mapNearbyHandler searches the places around the current location of the
+// user or a place.
+func mapNearbyHandler(ctx context.Context, content string, req models.Request) (models.NewsResponse, models.TextResponse) {
+	var mapTool models.MapTool
+
+	mapTool.NewTool(req)
+	mapTool.SetContext(ctx)
+
+	options, cmd := parseOptions(strings.Split(content, " "), "near")
+	if len(cmd) != 3 && len(cmd) != 4 { // map nearby KEYWORD [RADIUS] [--near PLACE]
+		return models.NewsResponse{}, mapToolHelpHandler(req, mapTool)
+	}
+	mapTool.Place = cmd[2]
+	if len(cmd) == 4 {
+		radius, err := strconv.Atoi(cmd[3])
+		if err != nil {
+			return models.NewsResponse{}, mapToolHelpHandler(req, mapTool)
+		}
+		mapTool.Radius = radius
+	}
+	mapTool.Near = options["near"]
+
+	resp, respText := mapTool.Nearby()
+	if respText.Content != "" {
+		respText.ToUserName = req.FromUserName
+		respText.FromUserName = req.ToUserName
+		respText.CreateTime = time.Duration(time.Now().Unix())
+		return resp, respText
+	}
+	resp.ToUserName = req.FromUserName
+	resp.FromUserName = req.ToUserName
+	resp.CreateTime = time.Duration(time.Now().Unix())
+	return resp, respText
+}
+
+func mapPickHandler(ctx context.Context, content string, req models.Request) interface{} {
+	var mapTool models.MapTool
+
+	mapTool.NewTool(req)
+	mapTool.SetContext(ctx)
+
+	cmd := strings.Split(content, " ")
+	if len(cmd) != 2 { // pick N
+		return mapToolHelpHandler(req, mapTool)
+	}
+	n, err := strconv.Atoi(cmd[1])
+	if err != nil {
+		return mapToolHelpHandler(req, mapTool)
+	}
+	mapTool.Choice = n
+	return mapReply(mapTool.Pick(), req)
+}
+
+// mapReply addresses the TextResponse or NewsResponse of the map tool to the
+// user.
+func mapReply(reply interface{}, req models.Request) interface{} {
+	switch resp := reply.(type) {
+	case models.NewsResponse:
+		resp.ToUserName = req.FromUserName
+		resp.FromUserName = req.ToUserName
+		resp.CreateTime = time.Duration(time.Now().Unix())
+		return resp
+	case models.TextResponse:
+		resp.ToUserName = req.FromUserName
+		resp.FromUserName = req.ToUserName
+		resp.CreateTime = time.Duration(time.Now().Unix())
+		return resp
+	}
+	return descriptionHandler(req)
+}
+
+// mapFromHereHandler plans the routes from the current location of the user
+// to a saved place or a place keyword.
+func mapFromHereHandler(ctx context.Context, destination string, req models.Request) interface{} {
+	var mapTool models.MapTool
+
+	mapTool.NewTool(req)
+	mapTool.SetContext(ctx)
+
+	mapTool.Destination = destination
+	return mapReply(mapTool.RouteReply((*models.MapTool).Directions), req)
+}
+
 func mapToolLocationHandler(req models.Request) models.TextResponse {
 	var mapTool models.MapTool
 	var resp models.TextResponse
@@ -222,7 +687,7 @@ func mapToolLocationHandler(req models.Request) models.TextResponse {
 	mapTool.Latlng = fmt.Sprintf("%f,%f", req.Location_X, req.Location_Y)
 	mapTool.Origin = req.Label
 
-	resp = mapTool.GoHome()
+	resp = mapTool.SetLocation()
 	resp.ToUserName = req.FromUserName
 	resp.FromUserName = req.ToUserName
 	resp.CreateTime = time.Duration(time.Now().Unix())
@@ -247,6 +712,11 @@ func subscribeHandler(req models.Request) models.TextResponse {
 	1. google(g)
 	2. dockercloud(dc)
 	3. map(m)
+	4. kubernetes(k8s)
+	5. env
+	6. status
+	7. search(s)
+	8. kb
 输入工具名获取使用帮助。`
 	resp.CreateTime = time.Duration(time.Now().Unix())
 	resp.MsgType = models.MsgTypeText
@@ -261,12 +731,66 @@ func descriptionHandler(req models.Request) models.TextResponse {
 	1. google(g)
 	2. dockercloud(dc)
 	3. map(m)
+	4. kubernetes(k8s)
+	5. env
+	6. status
+	7. search(s)
+	8. kb
 输入工具名获取使用帮助。`
 	resp.CreateTime = time.Duration(time.Now().Unix())
 	resp.MsgType = models.MsgTypeText
 	return resp
 }
 
+func textReplyHandler(req models.Request, content string) models.TextResponse {
+	var resp models.TextResponse
+	resp.ToUserName = req.FromUserName
+	resp.FromUserName = req.ToUserName
+	resp.Content = content
+	resp.CreateTime = time.Duration(time.Now().Unix())
+	resp.MsgType = models.MsgTypeText
+	return resp
+}
+
+// parseOptions separates the "--name value" and "-n value" options named by
+// names from the other arguments. Other words starting with "-", e.g. the
+// -term exclusion of a search, are kept as arguments.
+func parseOptions(args []string, names ...string) (map[string]string, []string) {
+	known := make(map[string]bool)
+	for _, name := range names {
+		known[name] = true
+	}
+	options := make(map[string]string)
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if strings.HasPrefix(args[i], "-") && known[name] && i+1 < len(args) {
+			options[name] = args[i+1]
+			i++
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+	return options, rest
+}
+
+// mergeTimeOptions joins the date and the clock of --depart and --arrive,
+// e.g. --depart tomorrow 08:30, into one option value.
+func mergeTimeOptions(args []string) []string {
+	var merged []string
+	for i := 0; i < len(args); i++ {
+		option := strings.TrimLeft(args[i], "-")
+		if strings.HasPrefix(args[i], "-") && (option == "depart" || option == "arrive") && i+2 < len(args) &&
+			!strings.Contains(args[i+1], ":") && strings.Contains(args[i+2], ":") {
+			merged = append(merged, args[i], args[i+1]+" "+args[i+2])
+			i += 2
+			continue
+		}
+		merged = append(merged, args[i])
+	}
+	return merged
+}
+
 func validatePrivilegedAction(req models.Request) (bool, models.TextResponse) {
 	var textResp models.TextResponse
 	users := beego.AppConfig.Strings("privilegeduser")
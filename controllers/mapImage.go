@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/astaxie/beego"
+	"github.com/xzdbd/ops-angel/models"
+)
+
+// MapImageController serves the static maps of the route cards, so the key
+// of the static map service never leaves the server.
+type MapImageController struct {
+	beego.Controller
+}
+
+func (c *MapImageController) Get() {
+	image, contentType, err := models.StaticMapImage(c.Ctx.Request.URL.Query())
+	if err == models.ErrMapImageSignature {
+		c.Ctx.Output.SetStatus(http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		beego.Error("Failed to get the map image. Error:", err.Error())
+		c.Ctx.Output.SetStatus(http.StatusBadGateway)
+		return
+	}
+	c.Ctx.Output.Header("Content-Type", contentType)
+	// the map of a signed query never changes
+	c.Ctx.Output.Header("Cache-Control", "public, max-age=86400")
+	c.Ctx.Output.Body(image)
+}
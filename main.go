@@ -3,9 +3,11 @@ package main
 import (
 	_ "github.com/xzdbd/ops-angel/routers"
 	"github.com/astaxie/beego"
+	"github.com/xzdbd/ops-angel/models"
 )
 
 func main() {
+	models.StartCommuteWatcher()
 	beego.Run()
 }
 
@@ -0,0 +1,306 @@
+package models
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/httplib"
+)
+
+const (
+	DefaultAPITimeout      = 10 * time.Second
+	DefaultAPIRetries      = 2
+	DefaultAPIRetryBackoff = 500 * time.Millisecond
+)
+
+// APIClient talks to the ops api, e.g. https://api.xzdbd.com/v1. GET requests
+// are retried with exponential backoff on network errors and 5xx responses.
+type APIClient struct {
+	BaseURL      string
+	User         string
+	Password     string
+	Timeout      time.Duration
+	TLSConfig    *tls.Config
+	Retries      int
+	RetryBackoff time.Duration
+	// Header is sent with every request, e.g. an api key
+	Header http.Header
+	// Transport keeps the connections to the api alive between requests,
+	// nil builds one per request
+	Transport http.RoundTripper
+	// Breaker fails requests fast while the api is unhealthy, nil disables it
+	Breaker *CircuitBreaker
+
+	// ctx cancels the requests of a client made by WithContext
+	ctx context.Context
+}
+
+// APIStatusError is returned when the api answers with a non 2xx status.
+type APIStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIStatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// APIAuthError is returned when the api rejects the credentials.
+type APIAuthError struct {
+	APIStatusError
+}
+
+func (e *APIAuthError) Error() string {
+	return fmt.Sprintf("%s %s: authentication failed (%d)", e.Method, e.URL, e.StatusCode)
+}
+
+// APIDecodeError is returned when the response body is not the expected json.
+type APIDecodeError struct {
+	URL string
+	Err error
+}
+
+func (e *APIDecodeError) Error() string {
+	return fmt.Sprintf("decode response of %s: %s", e.URL, e.Err)
+}
+
+// NewAPIClient returns a client for the api of env.
+func NewAPIClient(env *Environment) (*APIClient, error) {
+	tlsConfig, err := engineTLSConfig(env.APICA, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if env.APIInsecure {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.InsecureSkipVerify = true
+	}
+	baseURL := strings.TrimSuffix(env.APIAddress, "/") + "/" + APIVERSION
+	return &APIClient{
+		BaseURL:      baseURL,
+		User:         env.APIUser,
+		Password:     env.APIPassword,
+		Timeout:      env.APITimeout,
+		TLSConfig:    tlsConfig,
+		Retries:      env.APIRetries,
+		RetryBackoff: DefaultAPIRetryBackoff,
+		Breaker:      GetCircuitBreaker(baseURL),
+		Transport:    newAPITransport(tlsConfig, env.APITimeout),
+	}, nil
+}
+
+// newAPITransport returns a transport shared by the requests of a client.
+// Every field httplib fills in on a *http.Transport is set here, so the
+// shared transport is never written by concurrent requests.
+func newAPITransport(tlsConfig *tls.Config, timeout time.Duration) *http.Transport {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		TLSClientConfig:       tlsConfig,
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		Dial:                  dialer.Dial,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+}
+
+// WithContext returns a copy of the client whose requests stop when ctx is
+// done. The copy shares the transport and the breaker of c.
+func (c *APIClient) WithContext(ctx context.Context) *APIClient {
+	client := *c
+	client.ctx = ctx
+	return &client
+}
+
+func (c *APIClient) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Get decodes the json response of path into v, or stores the body as is if
+// v is a *[]byte. The retries of a request count once in the breaker.
+func (c *APIClient) Get(path string, params url.Values, v interface{}) error {
+	if err := c.allow(); err != nil {
+		return err
+	}
+	err := c.get(path, params, v)
+	c.record(err)
+	return err
+}
+
+func (c *APIClient) get(path string, params url.Values, v interface{}) error {
+	backoff := c.RetryBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		_, err = c.send("GET", path, params, v)
+		if err == nil || attempt >= c.Retries || !retryable(err) {
+			return err
+		}
+		beego.Warn("API request failed, retry in", backoff, "Error:", err)
+		select {
+		case <-time.After(backoff):
+		case <-c.context().Done():
+			return c.context().Err()
+		}
+		backoff *= 2
+	}
+}
+
+// CachedGet is Get answered from cache while the response to an identical
+// request is fresh in it. Responses without results are not cached, as the
+// place or route may be found on the next try.
+func (c *APIClient) CachedGet(cache *Cache, path string, params url.Values, v interface{}) error {
+	key := c.BaseURL + path + "?" + params.Encode()
+	if body, ok := cache.Get(key); ok {
+		if err := json.Unmarshal(body.(json.RawMessage), v); err != nil {
+			return &APIDecodeError{URL: key, Err: err}
+		}
+		return nil
+	}
+
+	var body json.RawMessage
+	if err := c.Get(path, params, &body); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return &APIDecodeError{URL: key, Err: err}
+	}
+	if !emptyResponse(body) {
+		cache.Set(key, body)
+	}
+	return nil
+}
+
+// emptyResponse reports whether body is null, an empty array, or an object
+// whose arrays, like results in {"results": [], "status": "ZERO_RESULTS"},
+// are all empty.
+func emptyResponse(body json.RawMessage) bool {
+	var values []json.RawMessage
+	if err := json.Unmarshal(body, &values); err == nil {
+		return len(values) == 0
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false
+	}
+	arrays := 0
+	for _, field := range fields {
+		if err := json.Unmarshal(field, &values); err != nil || values == nil {
+			continue
+		}
+		if len(values) > 0 {
+			return false
+		}
+		arrays++
+	}
+	return arrays > 0
+}
+
+// Post decodes the json response of path into v and returns the response
+// header. Posts are never retried.
+func (c *APIClient) Post(path string, params url.Values, v interface{}) (http.Header, error) {
+	if err := c.allow(); err != nil {
+		return nil, err
+	}
+	header, err := c.send("POST", path, params, v)
+	c.record(err)
+	return header, err
+}
+
+func (c *APIClient) allow() error {
+	if c.Breaker == nil {
+		return nil
+	}
+	return c.Breaker.Allow()
+}
+
+func (c *APIClient) record(err error) {
+	if c.Breaker != nil {
+		c.Breaker.RecordContext(c.context(), err)
+	}
+}
+
+func (c *APIClient) send(method string, path string, params url.Values, v interface{}) (http.Header, error) {
+	address := c.BaseURL + path
+	if len(params) > 0 {
+		address += "?" + params.Encode()
+	}
+	req := httplib.NewBeegoRequest(address, method)
+	withRequestContext(req, c.context())
+	req.SetTimeout(c.Timeout, c.Timeout)
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+	if c.Transport != nil {
+		req.SetTransport(c.Transport)
+	} else if c.TLSConfig != nil {
+		req.SetTLSClientConfig(c.TLSConfig)
+	}
+	for key := range c.Header {
+		req.Header(key, c.Header.Get(key))
+	}
+
+	resp, err := req.Response()
+	if err != nil {
+		return nil, err
+	}
+	body, err := req.Bytes()
+	if err != nil {
+		return resp.Header, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr := APIStatusError{Method: method, URL: address, StatusCode: resp.StatusCode,
+			Body: strings.TrimSpace(string(body))}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return resp.Header, &APIAuthError{statusErr}
+		}
+		return resp.Header, &statusErr
+	}
+	if raw, ok := v.(*[]byte); ok {
+		*raw = body
+	} else if v != nil {
+		if err := json.Unmarshal(body, v); err != nil {
+			return resp.Header, &APIDecodeError{URL: address, Err: err}
+		}
+	}
+	return resp.Header, nil
+}
+
+// withRequestContext makes the request stop when ctx is done. httplib has no
+// context option, so its *http.Request is replaced with one carrying ctx.
+func withRequestContext(req *httplib.BeegoHTTPRequest, ctx context.Context) {
+	r := req.GetRequest()
+	*r = *r.WithContext(ctx)
+}
+
+// retryable reports whether a failed GET may succeed when sent again.
+func retryable(err error) bool {
+	if err == ErrCircuitOpen {
+		return false
+	}
+	switch e := err.(type) {
+	case *APIStatusError:
+		return e.StatusCode >= 500
+	case *APIAuthError, *APIDecodeError:
+		return false
+	}
+	return true
+}
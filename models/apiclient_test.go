@@ -0,0 +1,137 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestAPIClient(handler http.HandlerFunc) (*APIClient, func()) {
+	server := httptest.NewServer(handler)
+	env := &Environment{
+		APIAddress:  server.URL + "/",
+		APIUser:     "user",
+		APIPassword: "secret",
+		APITimeout:  time.Second,
+		APIRetries:  2,
+	}
+	client, err := NewAPIClient(env)
+	if err != nil {
+		panic(err)
+	}
+	client.RetryBackoff = time.Millisecond
+	return client, server.Close
+}
+
+func TestAPIClient(t *testing.T) {
+	Convey("Subject: Shared api client\n", t, func() {
+		Convey("Requests carry the credentials and parameters", func() {
+			client, done := newTestAPIClient(func(w http.ResponseWriter, r *http.Request) {
+				user, password, _ := r.BasicAuth()
+				if r.URL.Path != "/v1/search" || user != "user" || password != "secret" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Write([]byte(`{"key": "` + r.URL.Query().Get("key") + `"}`))
+			})
+			defer done()
+			var result struct{ Key string }
+			err := client.Get("/search", url.Values{"key": {"happy day"}}, &result)
+			So(err, ShouldBeNil)
+			So(result.Key, ShouldEqual, "happy day")
+		})
+
+		Convey("GETs are retried on server errors", func() {
+			calls := 0
+			client, done := newTestAPIClient(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls < 3 {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				w.Write([]byte(`{}`))
+			})
+			defer done()
+			So(client.Get("/node", nil, nil), ShouldBeNil)
+			So(calls, ShouldEqual, 3)
+		})
+
+		Convey("Retries count once in the breaker", func() {
+			calls := 0
+			client, done := newTestAPIClient(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusBadGateway)
+			})
+			defer done()
+			client.Breaker = NewCircuitBreaker("retries", 5, time.Minute)
+			So(client.Get("/node", nil, nil), ShouldNotBeNil)
+			So(calls, ShouldEqual, 3)
+			So(client.Breaker.Status().Failures, ShouldEqual, 1)
+		})
+
+		Convey("Requests past the deadline of the caller don't open the circuit", func() {
+			client, done := newTestAPIClient(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(50 * time.Millisecond)
+			})
+			defer done()
+			client.Breaker = NewCircuitBreaker("caller deadline", 2, time.Minute)
+			for i := 0; i < 3; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+				So(client.WithContext(ctx).Get("/node", nil, nil), ShouldNotBeNil)
+				cancel()
+			}
+			So(client.Breaker.Status().State, ShouldEqual, BreakerClosed)
+			So(client.Breaker.Status().Failures, ShouldEqual, 0)
+		})
+
+		Convey("POSTs are not retried", func() {
+			calls := 0
+			client, done := newTestAPIClient(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusServiceUnavailable)
+			})
+			defer done()
+			_, err := client.Post("/stack/s1/stop", nil, nil)
+			So(err, ShouldHaveSameTypeAs, &APIStatusError{})
+			So(err.(*APIStatusError).StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("Auth failures and bad json are told apart", func() {
+			client, done := newTestAPIClient(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v1/private" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte(`<html>`))
+			})
+			defer done()
+			var v map[string]interface{}
+			So(client.Get("/private", nil, &v), ShouldHaveSameTypeAs, &APIAuthError{})
+			So(client.Get("/public", nil, &v), ShouldHaveSameTypeAs, &APIDecodeError{})
+		})
+	})
+}
+
+func TestEnvironmentClient(t *testing.T) {
+	Convey("Subject: Environments share their api client\n", t, func() {
+		env := &Environment{Name: "clienttest", APIAddress: "https://api.example.com/", APITimeout: time.Second}
+		first, err := getAPIClient(env)
+		So(err, ShouldBeNil)
+		second, err := getAPIClient(env)
+		So(err, ShouldBeNil)
+		So(second, ShouldEqual, first)
+		So(first.Transport, ShouldNotBeNil)
+
+		env.APIAddress = "https://api2.example.com/"
+		changed, err := getAPIClient(env)
+		So(err, ShouldBeNil)
+		So(changed, ShouldNotEqual, first)
+		So(changed.BaseURL, ShouldStartWith, "https://api2.example.com/")
+	})
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+const (
+	ServiceBackendDockerCloud = "dockercloud"
+	ServiceBackendEngine      = "engine"
+)
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrNotSupported = errors.New("当前后端不支持该操作。")
+
+	// engine backends by environment name, with the settings they were built
+	// from
+	engineBackends    = make(map[string]cachedEngineBackend)
+	engineBackendLock sync.Mutex
+)
+
+type cachedEngineBackend struct {
+	settings string
+	backend  *EngineBackend
+}
+
+// ServiceBackend is what the service commands of the ops tool run against.
+type ServiceBackend interface {
+	ListServices() ([]ServiceInfo, error)
+	// GetService returns ErrNotFound if there is no service with the name.
+	GetService(name string) (ServiceInfo, error)
+	ListContainers(service string) ([]ContainerInfo, error)
+	GetContainer(name string) (ContainerInfo, error)
+	// ServiceAction starts, stops, restarts or redeploys the service. It returns
+	// the uri of the action when the backend runs it asynchronously.
+	ServiceAction(name string, action string) (string, error)
+	ScaleService(name string, replicas int) error
+	ServiceLogs(name string, lines int) (string, error)
+}
+
+// ServiceInfo is the backend independent view of a service.
+type ServiceInfo struct {
+	Name       string
+	State      string
+	Image      string
+	Running    int
+	Stopped    int
+	Target     int
+	DeployedAt string
+	StartedAt  string
+	StoppedAt  string
+}
+
+// ContainerInfo is the backend independent view of a container.
+type ContainerInfo struct {
+	Name        string
+	State       string
+	Image       string
+	ImageTag    string
+	Node        string
+	Service     string
+	PublicDNS   string
+	StartedAt   string
+	StoppedAt   string
+	ExitCode    int
+	ExitMessage string
+}
+
+// NewServiceBackend returns the backend of env set by servicebackend in
+// app.conf, Docker Cloud by default.
+func NewServiceBackend(env *Environment) (ServiceBackend, error) {
+	switch env.ServiceBackend {
+	case ServiceBackendEngine:
+		backend, err := getEngineBackend(env)
+		if err != nil {
+			return nil, err
+		}
+		return backend.withContext(env.Context()), nil
+	case ServiceBackendDockerCloud:
+		return dockerCloudBackend{env: env}, nil
+	default:
+		return nil, fmt.Errorf("unknown service backend: %s", env.ServiceBackend)
+	}
+}
+
+// getEngineBackend builds the Engine backend of env once, again only when its
+// address or certificates change.
+func getEngineBackend(env *Environment) (*EngineBackend, error) {
+	settings := strings.Join([]string{env.EngineAddress, env.EngineTLSCA, env.EngineTLSCert, env.EngineTLSKey}, "|")
+	engineBackendLock.Lock()
+	defer engineBackendLock.Unlock()
+
+	if cached, ok := engineBackends[env.Name]; ok && cached.settings == settings {
+		return cached.backend, nil
+	}
+	tlsConfig, err := engineTLSConfig(env.EngineTLSCA, env.EngineTLSCert, env.EngineTLSKey)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := NewEngineBackend(env.EngineAddress, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	engineBackends[env.Name] = cachedEngineBackend{settings: settings, backend: backend}
+	return backend, nil
+}
+
+// engineTLSConfig returns nil when no certificate is configured.
+func engineTLSConfig(ca string, cert string, key string) (*tls.Config, error) {
+	if ca == "" && cert == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", ca)
+		}
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return tlsConfig, nil
+}
+
+func formatService(service ServiceInfo) string {
+	content := fmt.Sprintf("服务：%s\n", service.Name)
+	content += fmt.Sprintf("状态：%s\n", service.State)
+	content += fmt.Sprintf("镜像：%s\n", service.Image)
+	content += fmt.Sprintf("容器：%d个运行中，%d个已停止，目标%d个\n", service.Running,
+		service.Stopped, service.Target)
+	if service.DeployedAt != "" {
+		content += fmt.Sprintf("部署时间：%s\n", service.DeployedAt)
+	}
+	if service.StartedAt != "" {
+		content += fmt.Sprintf("启动时间：%s\n", service.StartedAt)
+	}
+	if service.StoppedAt != "" {
+		content += fmt.Sprintf("停止时间：%s\n", service.StoppedAt)
+	}
+	return content
+}
+
+func formatContainer(container ContainerInfo) string {
+	content := fmt.Sprintf("容器：%s\n", container.Name)
+	content += fmt.Sprintf("状态：%s\n", container.State)
+	content += fmt.Sprintf("镜像：%s\n", container.Image)
+	content += fmt.Sprintf("镜像标签：%s\n", container.ImageTag)
+	content += fmt.Sprintf("节点：%s\n", container.Node)
+	content += fmt.Sprintf("服务：%s\n", container.Service)
+	if container.PublicDNS != "" {
+		content += fmt.Sprintf("域名：%s\n", container.PublicDNS)
+	}
+	content += fmt.Sprintf("启动时间：%s\n", container.StartedAt)
+	if container.StoppedAt != "" {
+		content += fmt.Sprintf("停止时间：%s\n", container.StoppedAt)
+	}
+	if container.ExitMessage != "" {
+		content += fmt.Sprintf("退出码：%d (%s)\n", container.ExitCode, container.ExitMessage)
+	} else {
+		content += fmt.Sprintf("退出码：%d\n", container.ExitCode)
+	}
+	return content
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+var (
+	ErrCircuitOpen = errors.New("circuit open")
+
+	breakerThreshold = beego.AppConfig.DefaultInt("breakerthreshold", 5)
+	breakerCooldown  = time.Duration(beego.AppConfig.DefaultInt("breakercooldown", 30)) * time.Second
+
+	breakers     = make(map[string]*CircuitBreaker)
+	breakersLock sync.Mutex
+)
+
+// CircuitBreaker stops calling a backend after Threshold consecutive
+// failures. Once Cooldown has passed a single request is let through: its
+// success closes the circuit again, its failure keeps it open.
+type CircuitBreaker struct {
+	Name      string
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	lastError error
+}
+
+// BreakerStatus is a snapshot of a circuit breaker.
+type BreakerStatus struct {
+	Name      string
+	State     string
+	Failures  int
+	OpenedAt  time.Time
+	LastError error
+}
+
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Name: name, Threshold: threshold, Cooldown: cooldown, state: BreakerClosed}
+}
+
+// GetCircuitBreaker returns the breaker shared by every client of the backend.
+func GetCircuitBreaker(name string) *CircuitBreaker {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	b, ok := breakers[name]
+	if !ok {
+		b = NewCircuitBreaker(name, breakerThreshold, breakerCooldown)
+		breakers[name] = b
+	}
+	return b
+}
+
+// BreakerStatuses returns the state of every backend called so far.
+func BreakerStatuses() []BreakerStatus {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for _, b := range breakers {
+		statuses = append(statuses, b.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Allow returns ErrCircuitOpen while requests to the backend should fail fast.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		return nil
+	case BreakerHalfOpen:
+		// a trial request is in flight
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Record counts the outcome of a request let through by Allow. Only errors
+// telling that the backend is unhealthy count as failures.
+func (b *CircuitBreaker) Record(err error) {
+	b.RecordContext(context.Background(), err)
+}
+
+// RecordContext is Record for a request made with ctx. A request failing
+// because it was cancelled, or because ctx is done, was given up by its caller
+// rather than failed by the backend, so it counts neither way.
+func (b *CircuitBreaker) RecordContext(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil && (errors.Is(err, context.Canceled) || ctx.Err() != nil) {
+		if b.state == BreakerHalfOpen {
+			// the backend is still untried, let the next request try it
+			b.state = BreakerOpen
+		}
+		return
+	}
+	if err == nil || !backendUnavailable(err) {
+		if b.state != BreakerClosed {
+			beego.Info("Circuit closed:", b.Name)
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	b.lastError = err
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+		if b.state != BreakerOpen {
+			beego.Warn("Circuit opened:", b.Name, "Error:", err)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStatus{Name: b.Name, State: b.state, Failures: b.failures, OpenedAt: b.openedAt,
+		LastError: b.lastError}
+}
+
+// backendUnavailable reports whether err means the backend is down or
+// overloaded rather than the request being wrong.
+func backendUnavailable(err error) bool {
+	if e, ok := err.(*APIStatusError); ok {
+		return e.StatusCode >= 500
+	}
+	if status, ok := err.(apierrors.APIStatus); ok {
+		return status.Status().Code >= 500
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// BackendErrorMessage explains a failed backend request to the user. It
+// returns "" if err does not come from a backend.
+func BackendErrorMessage(err error) string {
+	if err == ErrCircuitOpen {
+		return fmt.Sprintf("后端服务暂时不可用，已暂停请求，请%d秒后再试。", int(breakerCooldown/time.Second))
+	}
+	if err == ErrNotFound {
+		return "没有找到该资源。"
+	}
+	switch e := err.(type) {
+	case *APIAuthError:
+		return "后端服务认证失败，请联系管理员检查API账号配置。"
+	case *APIStatusError:
+		if e.StatusCode == 404 {
+			return "没有找到该资源。"
+		}
+		if e.StatusCode >= 500 {
+			return fmt.Sprintf("后端服务出错(%d)，请稍后再试。", e.StatusCode)
+		}
+		return fmt.Sprintf("后端服务拒绝了请求(%d)：%s", e.StatusCode, e.Body)
+	case *APIDecodeError:
+		return "后端服务返回了无法识别的数据，请稍后再试。"
+	case net.Error:
+		if e.Timeout() {
+			return "后端服务响应超时，请稍后再试。"
+		}
+		return "无法连接后端服务，请稍后再试。"
+	}
+	return ""
+}
+
+// ErrorMessage is BackendErrorMessage falling back to the error text.
+func ErrorMessage(err error) string {
+	if msg := BackendErrorMessage(err); msg != "" {
+		return msg
+	}
+	return err.Error()
+}
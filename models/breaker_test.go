@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestCircuitBreaker(t *testing.T) {
+	Convey("Subject: Circuit breaker per backend\n", t, func() {
+		b := NewCircuitBreaker("api", 2, 20*time.Millisecond)
+		unavailable := &APIStatusError{StatusCode: http.StatusBadGateway}
+
+		Convey("The circuit opens after consecutive failures", func() {
+			b.Record(unavailable)
+			So(b.Allow(), ShouldBeNil)
+			b.Record(unavailable)
+			So(b.Allow(), ShouldEqual, ErrCircuitOpen)
+			So(b.Status().State, ShouldEqual, BreakerOpen)
+		})
+
+		Convey("Client errors do not count as failures", func() {
+			b.Record(unavailable)
+			b.Record(&APIStatusError{StatusCode: http.StatusNotFound})
+			b.Record(unavailable)
+			So(b.Allow(), ShouldBeNil)
+		})
+
+		Convey("A trial request after the cooldown closes the circuit", func() {
+			b.Record(unavailable)
+			b.Record(unavailable)
+			time.Sleep(30 * time.Millisecond)
+			So(b.Allow(), ShouldBeNil)
+			So(b.Allow(), ShouldEqual, ErrCircuitOpen)
+			b.Record(nil)
+			So(b.Status().State, ShouldEqual, BreakerClosed)
+		})
+
+		Convey("Requests given up by their caller do not count", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			cancelled := &url.Error{Op: "Get", URL: "http://api/", Err: context.Canceled}
+			for i := 0; i < 3; i++ {
+				b.Record(cancelled)
+				b.RecordContext(ctx, &url.Error{Op: "Get", URL: "http://api/", Err: context.DeadlineExceeded})
+			}
+			So(b.Allow(), ShouldBeNil)
+
+			b.Record(unavailable)
+			b.Record(cancelled)
+			b.Record(unavailable)
+			So(b.Allow(), ShouldEqual, ErrCircuitOpen)
+			time.Sleep(30 * time.Millisecond)
+			So(b.Allow(), ShouldBeNil)
+			b.Record(cancelled)
+			So(b.Allow(), ShouldBeNil)
+		})
+
+		Convey("Transport timeouts count", func() {
+			timeout := &url.Error{Op: "Get", URL: "http://api/", Err: timeoutError{}}
+			b.RecordContext(context.Background(), timeout)
+			b.RecordContext(context.Background(), timeout)
+			So(b.Allow(), ShouldEqual, ErrCircuitOpen)
+		})
+
+		Convey("A failed trial request opens the circuit again", func() {
+			b.Record(unavailable)
+			b.Record(unavailable)
+			time.Sleep(30 * time.Millisecond)
+			So(b.Allow(), ShouldBeNil)
+			b.Record(timeoutError{})
+			So(b.Allow(), ShouldEqual, ErrCircuitOpen)
+		})
+	})
+}
+
+func TestBackendErrorMessage(t *testing.T) {
+	Convey("Subject: Classify backend errors\n", t, func() {
+		So(BackendErrorMessage(timeoutError{}), ShouldContainSubstring, "超时")
+		So(BackendErrorMessage(&APIStatusError{StatusCode: 503}), ShouldContainSubstring, "503")
+		So(BackendErrorMessage(&APIAuthError{APIStatusError{StatusCode: 401}}), ShouldContainSubstring, "认证失败")
+		So(BackendErrorMessage(&APIStatusError{StatusCode: 404}), ShouldStartWith, "没有找到")
+		So(BackendErrorMessage(ErrCircuitOpen), ShouldContainSubstring, "暂停请求")
+		So(BackendErrorMessage(errors.New("Invalid Action")), ShouldBeEmpty)
+	})
+}
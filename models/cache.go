@@ -0,0 +1,130 @@
+package models
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+var (
+	cacheSize = beego.AppConfig.DefaultInt("cachesize", 1000)
+
+	// per tool ttls, in seconds. searchcachettl was googlecachettl before
+	// there were search providers, the old key is still read. Nearby places
+	// show whether they are open now, so they are kept briefly.
+	searchCache     = NewCache("search", cacheSize, time.Duration(beego.AppConfig.DefaultInt("searchcachettl", beego.AppConfig.DefaultInt("googlecachettl", 600)))*time.Second)
+	placeCache      = NewCache("place", cacheSize, time.Duration(beego.AppConfig.DefaultInt("placecachettl", 86400))*time.Second)
+	directionsCache = NewCache("directions", cacheSize, time.Duration(beego.AppConfig.DefaultInt("directionscachettl", 300))*time.Second)
+	nearbyCache     = NewCache("nearby", cacheSize, time.Duration(beego.AppConfig.DefaultInt("nearbycachettl", 300))*time.Second)
+	// favicon url of each host, "" if the host has none
+	faviconCache = NewCache("favicon", cacheSize, time.Duration(beego.AppConfig.DefaultInt("faviconcachettl", 86400))*time.Second)
+
+	// the caches shown by AllCacheStats
+	caches = []*Cache{searchCache, placeCache, directionsCache, nearbyCache, faviconCache}
+)
+
+// Cache is an in-memory cache whose entries expire after TTL. When it holds
+// Capacity entries the least recently used one is evicted. A zero TTL disables
+// the cache.
+type Cache struct {
+	Name     string
+	Capacity int
+	TTL      time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	lru       *list.List
+	hits      int
+	misses    int
+	evictions int
+}
+
+// CacheStats is a snapshot of the counters of a cache.
+type CacheStats struct {
+	Name      string
+	Size      int
+	Hits      int
+	Misses    int
+	Evictions int
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewCache creates a cache. Only the caches of the tools are listed in
+// AllCacheStats.
+func NewCache(name string, capacity int, ttl time.Duration) *Cache {
+	c := &Cache{
+		Name:     name,
+		Capacity: capacity,
+		TTL:      ttl,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+	return c
+}
+
+// AllCacheStats returns the counters of every cache.
+func AllCacheStats() []CacheStats {
+	stats := make([]CacheStats, 0, len(caches))
+	for _, c := range caches {
+		stats = append(stats, c.Stats())
+	}
+	return stats
+}
+
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		c.misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	c.hits++
+	return entry.value, true
+}
+
+func (c *Cache) Set(key string, value interface{}) {
+	if c.TTL <= 0 || c.Capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.TTL)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.value = value
+		entry.expires = expires
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	for c.lru.Len() > c.Capacity {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Name: c.Name, Size: c.lru.Len(), Hits: c.hits, Misses: c.misses, Evictions: c.evictions}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
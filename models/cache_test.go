@@ -0,0 +1,83 @@
+package models
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	Convey("Subject: TTL and LRU cache\n", t, func() {
+		c := NewCache("test", 2, 20*time.Millisecond)
+
+		Convey("Entries expire after the ttl", func() {
+			c.Set("a", 1)
+			v, ok := c.Get("a")
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, 1)
+			time.Sleep(30 * time.Millisecond)
+			_, ok = c.Get("a")
+			So(ok, ShouldBeFalse)
+			So(c.Stats().Size, ShouldEqual, 0)
+		})
+
+		Convey("The least recently used entry is evicted", func() {
+			c.Set("a", 1)
+			c.Set("b", 2)
+			c.Get("a")
+			c.Set("c", 3)
+			_, ok := c.Get("b")
+			So(ok, ShouldBeFalse)
+			_, ok = c.Get("a")
+			So(ok, ShouldBeTrue)
+			So(c.Stats(), ShouldResemble, CacheStats{Name: "test", Size: 2, Hits: 2, Misses: 1, Evictions: 1})
+		})
+
+		Convey("A zero ttl disables the cache", func() {
+			c.TTL = 0
+			c.Set("a", 1)
+			_, ok := c.Get("a")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestCachedGet(t *testing.T) {
+	Convey("Subject: Cached api requests\n", t, func() {
+		calls := 0
+		client, done := newTestAPIClient(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if r.URL.Query().Get("keyword") == "nothing" {
+				w.Write([]byte(`{"Results": [], "Status": "ZERO_RESULTS"}`))
+				return
+			}
+			w.Write([]byte(`{"PlaceID": "` + r.URL.Query().Get("keyword") + `"}`))
+		})
+		defer done()
+		cache := NewCache("place test", 10, time.Minute)
+
+		var first, second, other struct{ PlaceID string }
+		So(client.CachedGet(cache, "/map/place/search", url.Values{"keyword": {"home"}}, &first), ShouldBeNil)
+		So(client.CachedGet(cache, "/map/place/search", url.Values{"keyword": {"home"}}, &second), ShouldBeNil)
+		So(client.CachedGet(cache, "/map/place/search", url.Values{"keyword": {"office"}}, &other), ShouldBeNil)
+		So(second.PlaceID, ShouldEqual, "home")
+		So(other.PlaceID, ShouldEqual, "office")
+		So(calls, ShouldEqual, 2)
+
+		Convey("Responses without results are asked again", func() {
+			var empty struct{ Results []string }
+			So(client.CachedGet(cache, "/map/place/search", url.Values{"keyword": {"nothing"}}, &empty), ShouldBeNil)
+			So(client.CachedGet(cache, "/map/place/search", url.Values{"keyword": {"nothing"}}, &empty), ShouldBeNil)
+			So(calls, ShouldEqual, 4)
+		})
+
+		Convey("Only the caches of the tools are listed", func() {
+			for _, stats := range AllCacheStats() {
+				So(stats.Name, ShouldNotEqual, "place test")
+			}
+		})
+	})
+}
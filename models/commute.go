@@ -0,0 +1,554 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+const (
+	// CommuteWatchFile keeps the commute watches across restarts
+	CommuteWatchFile = "conf/watches.json"
+
+	// durations of the recent runs kept to learn the usual commute
+	commuteHistory = 20
+	// runs needed before a commute is compared with the usual one
+	commuteMinHistory = 3
+)
+
+var (
+	ErrNoCommuteOrigin = errors.New("请使用--from PLACE指定出发地点，或先发送位置信息。")
+
+	// a run missed while the server was down is made up within the grace
+	commuteGrace = time.Duration(beego.AppConfig.DefaultInt("commutegrace", 15)) * time.Minute
+	// a commute this many times the usual one is alerted
+	commuteAlertRatio = beego.AppConfig.DefaultFloat("commutealertratio", 1.3)
+
+	commuteDayNames = map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+		"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	}
+	commuteDayAliases = map[string]string{
+		"daily": "daily", "everyday": "daily", "每天": "daily",
+		"weekdays": "weekdays", "工作日": "weekdays",
+		"weekends": "weekends", "周末": "weekends",
+	}
+	commuteDayTitles = map[string]string{"daily": "每天", "weekdays": "工作日", "weekends": "周末"}
+
+	commuteWatcher = &CommuteWatcher{Path: beego.AppConfig.DefaultString("watchfile", CommuteWatchFile)}
+
+	// commutePush sends the result of a run to the user
+	commutePush = PushReply
+)
+
+// CommuteWatch plans the route of a user at Time on Days and pushes it.
+type CommuteWatch struct {
+	UserID          string
+	Name            string
+	Time            string
+	Days            string
+	Timezone        string
+	Mode            string
+	OriginID        string
+	OriginName      string
+	DestinationID   string
+	DestinationName string
+	// LastRun is the date of the last run in Timezone
+	LastRun string
+	// Durations are the commutes of the recent runs in seconds
+	Durations []int64
+	// Paused watches don't run, as the user sent no message in the 48 hours
+	// routes may be pushed. The next message of the user resumes them.
+	Paused bool `json:",omitempty"`
+}
+
+// CommuteWatcher runs the watches it saves in Path.
+type CommuteWatcher struct {
+	sync.Mutex
+	Path    string
+	watches []*CommuteWatch
+}
+
+// StartCommuteWatcher loads the saved watches and runs them when they are
+// due.
+func StartCommuteWatcher() {
+	if err := commuteWatcher.Load(); err != nil {
+		beego.Error("Failed to load commute watches:", err.Error())
+	}
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			commuteWatcher.RunDue(now)
+		}
+	}()
+}
+
+// Load reads the watches saved in Path. A missing file holds no watches.
+func (w *CommuteWatcher) Load() error {
+	w.Lock()
+	defer w.Unlock()
+	data, err := ioutil.ReadFile(w.Path)
+	if os.IsNotExist(err) {
+		w.watches = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var watches []*CommuteWatch
+	if err := json.Unmarshal(data, &watches); err != nil {
+		return err
+	}
+	w.watches = watches
+	beego.Info("Commute watches loaded:", len(watches))
+	return nil
+}
+
+// save writes the watches to a temporary file renamed over Path, so a crash
+// never leaves half a file. The caller holds the lock.
+func (w *CommuteWatcher) save() error {
+	data, err := json.MarshalIndent(w.watches, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := w.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.Path)
+}
+
+// Watch adds the watch, replacing the watch of the user with the same name,
+// time and days.
+func (w *CommuteWatcher) Watch(watch CommuteWatch) error {
+	w.Lock()
+	defer w.Unlock()
+	for i, existing := range w.watches {
+		if existing.sameAs(watch) {
+			// the usual commute is kept when the route is the same
+			if existing.OriginID == watch.OriginID && existing.DestinationID == watch.DestinationID &&
+				existing.Mode == watch.Mode {
+				watch.Durations = existing.Durations
+			}
+			w.watches[i] = &watch
+			return w.save()
+		}
+	}
+	w.watches = append(w.watches, &watch)
+	return w.save()
+}
+
+// Unwatch removes the watches of the user matching remove and returns how
+// many there were.
+func (w *CommuteWatcher) Unwatch(userID string, remove func(CommuteWatch) bool) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	var kept []*CommuteWatch
+	for _, watch := range w.watches {
+		if watch.UserID != userID || !remove(*watch) {
+			kept = append(kept, watch)
+		}
+	}
+	removed := len(w.watches) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	w.watches = kept
+	return removed, w.save()
+}
+
+// Watches returns copies of the watches of the user by time.
+func (w *CommuteWatcher) Watches(userID string) []CommuteWatch {
+	w.Lock()
+	defer w.Unlock()
+	var watches []CommuteWatch
+	for _, watch := range w.watches {
+		if watch.UserID == userID {
+			watches = append(watches, *watch)
+		}
+	}
+	sort.SliceStable(watches, func(i, j int) bool {
+		return watches[i].Time < watches[j].Time
+	})
+	return watches
+}
+
+// RunDue runs the watches due at now, each at most once a day.
+func (w *CommuteWatcher) RunDue(now time.Time) {
+	w.Lock()
+	var due []CommuteWatch
+	for _, watch := range w.watches {
+		if date, ok := watch.due(now); ok {
+			watch.LastRun = date
+			due = append(due, *watch)
+		}
+	}
+	if len(due) > 0 {
+		if err := w.save(); err != nil {
+			beego.Error("Failed to save commute watches:", err.Error())
+		}
+	}
+	w.Unlock()
+
+	for _, watch := range due {
+		go w.run(watch)
+	}
+}
+
+// run plans the route of the watch, pushes it to the user and learns the
+// duration.
+func (w *CommuteWatcher) run(watch CommuteWatch) {
+	beego.Info("Run commute watch. User:", watch.UserID, "Name:", watch.Name, "Time:", watch.Time)
+	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+	defer cancel()
+	routes, err := getDirections(ctx, watch.OriginID, watch.DestinationID, watch.Mode, url.Values{})
+	if err != nil {
+		beego.Error("Commute watch failed. User:", watch.UserID, "Name:", watch.Name, "Error:", err.Error())
+		w.push(watch, fmt.Sprintf("【通勤提醒】%s\n查询路线失败：%s", watch.title(), ErrorMessage(err)))
+		return
+	}
+	route := routes[0]
+	duration := route.Legs[0].Duration.Value
+
+	content := fmt.Sprintf("【通勤提醒】%s\n", watch.title())
+	if alert := commuteAlert(duration, watch.Durations); alert != "" {
+		content += alert + "\n"
+	}
+	content += formatRoute(route, watch.Mode)
+	if w.push(watch, content) && duration > 0 {
+		w.record(watch, duration)
+	}
+}
+
+// push pushes the content of a run and reports whether the user got it. The
+// watch is paused when the user can't be pushed to.
+func (w *CommuteWatcher) push(watch CommuteWatch, content string) bool {
+	err := commutePush(watch.UserID, TextResponse{Content: content})
+	if err == ErrPushOutOfTime {
+		beego.Info("Pause commute watch, the user sent no message in 48 hours. User:", watch.UserID, "Name:", watch.Name)
+		w.setPaused(watch, true)
+		return false
+	}
+	if err != nil {
+		beego.Error("Failed to push commute. User:", watch.UserID, "Error:", err.Error())
+		return false
+	}
+	return true
+}
+
+func (w *CommuteWatcher) setPaused(paused CommuteWatch, on bool) {
+	w.Lock()
+	defer w.Unlock()
+	for _, watch := range w.watches {
+		if watch.sameAs(paused) {
+			watch.Paused = on
+			if err := w.save(); err != nil {
+				beego.Error("Failed to save commute watches:", err.Error())
+			}
+			return
+		}
+	}
+}
+
+// Resume resumes the paused watches of the user and returns them.
+func (w *CommuteWatcher) Resume(userID string) []CommuteWatch {
+	w.Lock()
+	defer w.Unlock()
+	var resumed []CommuteWatch
+	for _, watch := range w.watches {
+		if watch.UserID == userID && watch.Paused {
+			watch.Paused = false
+			resumed = append(resumed, *watch)
+		}
+	}
+	if len(resumed) > 0 {
+		if err := w.save(); err != nil {
+			beego.Error("Failed to save commute watches:", err.Error())
+		}
+	}
+	return resumed
+}
+
+// ResumeCommuteWatches resumes the watches paused while the user sent no
+// message and tells the user in the background. It is called on every
+// message of the user, which lets routes be pushed again.
+func ResumeCommuteWatches(openID string) {
+	resumed := commuteWatcher.Resume(openID)
+	if len(resumed) == 0 {
+		return
+	}
+	beego.Info("Resume commute watches. User:", openID, "Count:", len(resumed))
+	content := fmt.Sprintf("由于48小时内没有收到您的消息，无法推送路线，以下通勤提醒已暂停，现已恢复：\n")
+	for i, watch := range resumed {
+		content += fmt.Sprintf("%d. %s：%s\n", i+1, watch.Name, watch.title())
+	}
+	go func() {
+		if err := commutePush(openID, TextResponse{Content: content}); err != nil {
+			beego.Error("Failed to push resumed commute watches. User:", openID, "Error:", err.Error())
+		}
+	}()
+}
+
+// record adds the duration of a run to the history of the watch.
+func (w *CommuteWatcher) record(run CommuteWatch, duration int64) {
+	w.Lock()
+	defer w.Unlock()
+	for _, watch := range w.watches {
+		if watch.sameAs(run) {
+			watch.Durations = append(watch.Durations, duration)
+			if len(watch.Durations) > commuteHistory {
+				watch.Durations = watch.Durations[len(watch.Durations)-commuteHistory:]
+			}
+			if err := w.save(); err != nil {
+				beego.Error("Failed to save commute watches:", err.Error())
+			}
+			return
+		}
+	}
+}
+
+// due tells whether the watch should run at now and returns the date of the
+// run in the time zone of the watch.
+func (watch *CommuteWatch) due(now time.Time) (string, bool) {
+	loc, err := time.LoadLocation(watch.Timezone)
+	if err != nil {
+		loc = time.Local
+	}
+	now = now.In(loc)
+	date := now.Format("2006-01-02")
+	if watch.LastRun == date || watch.Paused {
+		return "", false
+	}
+	clock, err := time.Parse("15:04", watch.Time)
+	if err != nil {
+		return "", false
+	}
+	scheduled := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	if now.Before(scheduled) || now.Sub(scheduled) > commuteGrace {
+		return "", false
+	}
+	days, err := commuteDays(watch.Days)
+	if err != nil || !days[now.Weekday()] {
+		return "", false
+	}
+	return date, true
+}
+
+// sameAs tells whether other is the same watch: a user may watch a place at
+// several times.
+func (watch *CommuteWatch) sameAs(other CommuteWatch) bool {
+	return watch.UserID == other.UserID && watch.Name == other.Name && watch.Time == other.Time &&
+		watch.Days == other.Days
+}
+
+func (watch *CommuteWatch) title() string {
+	return fmt.Sprintf("%s %s 从%s到%s", commuteDayTitle(watch.Days), watch.Time, watch.OriginName, watch.DestinationName)
+}
+
+// commuteAlert warns when duration is much longer than the median of the
+// history, empty otherwise.
+func commuteAlert(duration int64, history []int64) string {
+	if len(history) < commuteMinHistory || duration <= 0 {
+		return ""
+	}
+	sorted := append([]int64(nil), history...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	usual := sorted[len(sorted)/2]
+	if float64(duration) < float64(usual)*commuteAlertRatio {
+		return ""
+	}
+	return fmt.Sprintf("⚠ 路况异常：预计用时%d分钟，比平时的%d分钟多%d分钟。", (duration+30)/60, (usual+30)/60,
+		(duration-usual+30)/60)
+}
+
+// commuteDays parses daily, weekdays, weekends or days like mon,wed,fri into
+// the weekdays they cover.
+func commuteDays(days string) (map[time.Weekday]bool, error) {
+	weekdays := make(map[time.Weekday]bool)
+	switch commuteDayAliases[strings.ToLower(days)] {
+	case "daily":
+		for _, day := range commuteDayNames {
+			weekdays[day] = true
+		}
+		return weekdays, nil
+	case "weekdays":
+		for day := time.Monday; day <= time.Friday; day++ {
+			weekdays[day] = true
+		}
+		return weekdays, nil
+	case "weekends":
+		weekdays[time.Saturday], weekdays[time.Sunday] = true, true
+		return weekdays, nil
+	}
+	for _, name := range strings.Split(strings.ToLower(days), ",") {
+		day, ok := commuteDayNames[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("无法识别日期%s，可选：daily, weekdays, weekends或mon,wed,fri。", days)
+		}
+		weekdays[day] = true
+	}
+	return weekdays, nil
+}
+
+// normalizeCommuteDays returns the canonical form of days.
+func normalizeCommuteDays(days string) (string, error) {
+	if days == "" {
+		return "daily", nil
+	}
+	if alias, ok := commuteDayAliases[strings.ToLower(days)]; ok {
+		return alias, nil
+	}
+	if _, err := commuteDays(days); err != nil {
+		return "", err
+	}
+	return strings.ToLower(strings.Replace(days, " ", "", -1)), nil
+}
+
+func commuteDayTitle(days string) string {
+	if title, ok := commuteDayTitles[days]; ok {
+		return title
+	}
+	return days
+}
+
+// Watch schedules the route from Origin, else the current location, to
+// Destination at Depart on Days and pushes it to the user.
+func (m *MapTool) Watch() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+
+	clock, err := time.Parse("15:04", m.Depart)
+	if err != nil {
+		textResp.Content = fmt.Sprintf("无法识别时间%s，格式为HH:MM，例如18:00。", m.Depart)
+		return textResp
+	}
+	days, err := normalizeCommuteDays(m.Days)
+	if err != nil {
+		textResp.Content = err.Error()
+		return textResp
+	}
+	mode, err := m.travelMode()
+	if err != nil {
+		textResp.Content = err.Error()
+		return textResp
+	}
+	loc, err := m.location()
+	if err != nil {
+		textResp.Content = err.Error()
+		return textResp
+	}
+
+	destination, err := m.resolvePlaceCandidate(m.Destination)
+	if err != nil {
+		textResp.Content = m.placeError(err, "查找地点失败，请尝试其他地点关键词。", (*MapTool).Watch)
+		return textResp
+	}
+	var origin PlaceCandidate
+	if m.Origin != "" {
+		origin, err = m.resolvePlaceCandidate(m.Origin)
+	} else if location, ok := GetCurrentLocation(m.UserID); ok {
+		origin.Name = location.Label
+		origin.PlaceID, err = m.locationPlaceID(location)
+	} else {
+		textResp.Content = ErrNoCommuteOrigin.Error()
+		return textResp
+	}
+	if err != nil {
+		textResp.Content = m.placeError(err, "查找地点失败，请尝试其他地点关键词。", (*MapTool).Watch)
+		return textResp
+	}
+
+	watch := CommuteWatch{
+		UserID:          m.UserID,
+		Name:            strings.ToLower(m.Destination),
+		Time:            clock.Format("15:04"),
+		Days:            days,
+		Timezone:        loc.String(),
+		Mode:            mode,
+		OriginID:        origin.PlaceID,
+		OriginName:      placeLabel(m.Origin, origin),
+		DestinationID:   destination.PlaceID,
+		DestinationName: placeLabel(m.Destination, destination),
+	}
+	if err := commuteWatcher.Watch(watch); err != nil {
+		beego.Error("Failed to save commute watch:", err.Error())
+		textResp.Content = fmt.Sprintf("设置通勤提醒失败。")
+		return textResp
+	}
+	beego.Info("Set commute watch. User:", m.UserID, "Name:", watch.Name, "Time:", watch.Time, "Days:", watch.Days)
+	textResp.Content = fmt.Sprintf("已设置通勤提醒：%s，将推送%s路线。", watch.title(), mapModeNames[mode])
+	return textResp
+}
+
+// Watches lists the commute watches of the user.
+func (m *MapTool) Watches() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	watches := commuteWatcher.Watches(m.UserID)
+	if len(watches) == 0 {
+		textResp.Content = fmt.Sprintf("用户还未设置通勤提醒，使用map watch PLACE HH:MM [DAYS]来设置。")
+		return textResp
+	}
+	textResp.Content = fmt.Sprintf("共有%d个通勤提醒：\n", len(watches))
+	for i, watch := range watches {
+		textResp.Content += fmt.Sprintf("%d. %s：%s\n", i+1, watch.Name, watch.title())
+	}
+	return textResp
+}
+
+// Unwatch removes the commute watches named Destination, or watch number N
+// of Watches if Destination is a number.
+func (m *MapTool) Unwatch() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	name := strings.ToLower(m.Destination)
+	remove := func(watch CommuteWatch) bool {
+		return watch.Name == name
+	}
+	if n, err := strconv.Atoi(m.Destination); err == nil {
+		watches := commuteWatcher.Watches(m.UserID)
+		if n < 1 || n > len(watches) {
+			textResp.Content = fmt.Sprintf("没有第%d个通勤提醒，使用map watches查看。", n)
+			return textResp
+		}
+		numbered := watches[n-1]
+		name = numbered.Name
+		remove = numbered.sameAs
+	}
+	removed, err := commuteWatcher.Unwatch(m.UserID, remove)
+	if err != nil {
+		textResp.Content = fmt.Sprintf("删除通勤提醒失败。")
+		return textResp
+	}
+	if removed == 0 {
+		textResp.Content = fmt.Sprintf("没有名为%s的通勤提醒，使用map watches查看。", m.Destination)
+		return textResp
+	}
+	if removed > 1 {
+		textResp.Content = fmt.Sprintf("已删除%d个通勤提醒%s。", removed, name)
+		return textResp
+	}
+	textResp.Content = fmt.Sprintf("已删除通勤提醒%s。", name)
+	return textResp
+}
+
+// placeLabel names a place by the keyword the user typed, else by the name
+// found.
+func placeLabel(keyword string, place PlaceCandidate) string {
+	if keyword != "" {
+		return keyword
+	}
+	return place.Name
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommuteDays(t *testing.T) {
+	Convey("Subject: Days of a commute watch\n", t, func() {
+		days, err := commuteDays("weekdays")
+		So(err, ShouldBeNil)
+		So(days[time.Monday] && days[time.Friday], ShouldBeTrue)
+		So(days[time.Saturday], ShouldBeFalse)
+
+		days, err = commuteDays("mon,wed")
+		So(err, ShouldBeNil)
+		So(days, ShouldResemble, map[time.Weekday]bool{time.Monday: true, time.Wednesday: true})
+
+		normalized, err := normalizeCommuteDays("工作日")
+		So(err, ShouldBeNil)
+		So(normalized, ShouldEqual, "weekdays")
+		normalized, err = normalizeCommuteDays("")
+		So(err, ShouldBeNil)
+		So(normalized, ShouldEqual, "daily")
+		_, err = normalizeCommuteDays("someday")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCommuteDue(t *testing.T) {
+	Convey("Subject: When a commute watch runs\n", t, func() {
+		watch := CommuteWatch{Time: "18:00", Days: "weekdays", Timezone: "Asia/Shanghai"}
+		loc, _ := time.LoadLocation("Asia/Shanghai")
+		// 2026-10-19 is a Monday
+		monday := time.Date(2026, 10, 19, 18, 0, 20, 0, loc)
+
+		date, ok := watch.due(monday)
+		So(ok, ShouldBeTrue)
+		So(date, ShouldEqual, "2026-10-19")
+
+		_, ok = watch.due(monday.Add(-time.Minute))
+		So(ok, ShouldBeFalse)
+		// made up after a restart within the grace
+		_, ok = watch.due(monday.Add(10 * time.Minute))
+		So(ok, ShouldBeTrue)
+		_, ok = watch.due(monday.Add(commuteGrace + time.Minute))
+		So(ok, ShouldBeFalse)
+		// weekends are skipped
+		_, ok = watch.due(monday.AddDate(0, 0, 5))
+		So(ok, ShouldBeFalse)
+
+		watch.LastRun = date
+		_, ok = watch.due(monday.Add(time.Minute))
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestCommuteAlert(t *testing.T) {
+	Convey("Subject: Alert on a commute much worse than usual\n", t, func() {
+		So(commuteAlert(3600, []int64{1800, 1800}), ShouldEqual, "")
+		So(commuteAlert(2000, []int64{1800, 1700, 1900}), ShouldEqual, "")
+		So(commuteAlert(3000, []int64{1800, 1700, 1800, 6000}), ShouldEqual, "⚠ 路况异常：预计用时50分钟，比平时的30分钟多20分钟。")
+	})
+}
+
+func TestCommuteWatcher(t *testing.T) {
+	Convey("Subject: Commute watches survive restarts and push routes\n", t, func() {
+		dir, _ := ioutil.TempDir("", "watches")
+		defer os.RemoveAll(dir)
+		oldWatcher, oldPush := commuteWatcher, commutePush
+		defer func() {
+			commuteWatcher, commutePush = oldWatcher, oldPush
+		}()
+		commuteWatcher = &CommuteWatcher{Path: filepath.Join(dir, "watches.json")}
+		pushed := make(map[string]string)
+		commutePush = func(openID string, reply interface{}) error {
+			pushed[openID] = reply.(TextResponse).Content
+			return nil
+		}
+
+		done := useTempUserConfig("[commuter]\nid=homeid\naddress=滨江区江南大道\n")
+		defer done()
+		last, closeMap := newFakeMap(`{"Routes": [{"legs": [{"duration": {"text": "50分钟", "value": 3000}, "distance": {"text": "12 公里"}}]}]}`,
+			map[string]string{"office": `[{"name": "office", "place_id": "officeid", "formatted_address": "西湖区文三路"}]`})
+		defer closeMap()
+
+		var req Request
+		req.FromUserName = "commuter"
+		var m MapTool
+		m.NewTool(req)
+
+		Convey("A watch needs an origin", func() {
+			m.Destination, m.Depart = "home", "18:00"
+			So(m.Watch().Content, ShouldEqual, ErrNoCommuteOrigin.Error())
+		})
+
+		Convey("A watch is saved, listed and run", func() {
+			m.Destination, m.Depart, m.Days, m.Origin = "home", "18:00", "weekdays", "office"
+			So(m.Watch().Content, ShouldEqual, "已设置通勤提醒：工作日 18:00 从office到home，将推送公交路线。")
+			So(m.Watches().Content, ShouldEqual, "共有1个通勤提醒：\n1. home：工作日 18:00 从office到home\n")
+
+			restarted := &CommuteWatcher{Path: commuteWatcher.Path}
+			So(restarted.Load(), ShouldBeNil)
+			watches := restarted.Watches("commuter")
+			So(watches, ShouldHaveLength, 1)
+			So(watches[0].OriginID, ShouldEqual, "officeid")
+			So(watches[0].DestinationID, ShouldEqual, "homeid")
+
+			restarted.watches[0].Durations = []int64{1800, 1700, 1900}
+			restarted.run(*restarted.watches[0])
+			So(last.Get("origin"), ShouldEqual, "officeid")
+			So(pushed["commuter"], ShouldStartWith, "【通勤提醒】工作日 18:00 从office到home\n⚠ 路况异常：预计用时50分钟")
+			So(restarted.Watches("commuter")[0].Durations, ShouldResemble, []int64{1800, 1700, 1900, 3000})
+
+			m.Destination = "home"
+			So(m.Unwatch().Content, ShouldEqual, "已删除通勤提醒home。")
+			So(m.Watches().Content, ShouldStartWith, "用户还未设置通勤提醒")
+		})
+
+		Convey("A watch is paused when the user can't be pushed to, until the next message", func() {
+			m.Destination, m.Depart, m.Days, m.Origin = "home", "18:00", "daily", "office"
+			m.Watch()
+			commutePush = func(openID string, reply interface{}) error {
+				return ErrPushOutOfTime
+			}
+			commuteWatcher.run(commuteWatcher.Watches("commuter")[0])
+			paused := commuteWatcher.Watches("commuter")[0]
+			So(paused.Paused, ShouldBeTrue)
+			So(paused.Durations, ShouldBeEmpty)
+			loc, _ := time.LoadLocation(paused.Timezone)
+			evening := time.Date(2026, 10, 19, 18, 1, 0, 0, loc)
+			_, due := paused.due(evening)
+			So(due, ShouldBeFalse)
+
+			resumed := make(chan string, 1)
+			commutePush = func(openID string, reply interface{}) error {
+				resumed <- reply.(TextResponse).Content
+				return nil
+			}
+			ResumeCommuteWatches("commuter")
+			So(<-resumed, ShouldEqual, "由于48小时内没有收到您的消息，无法推送路线，以下通勤提醒已暂停，现已恢复：\n1. home：每天 18:00 从office到home\n")
+			watch := commuteWatcher.Watches("commuter")[0]
+			_, due = watch.due(evening)
+			So(due, ShouldBeTrue)
+
+			ResumeCommuteWatches("commuter")
+			So(resumed, ShouldBeEmpty)
+		})
+
+		Convey("A place is watched at several times", func() {
+			m.Destination, m.Depart, m.Days, m.Origin = "home", "18:00", "weekdays", "office"
+			m.Watch()
+			m.Depart, m.Days = "08:00", "weekends"
+			m.Watch()
+			m.Mode = MapModeDriving
+			m.Watch()
+			So(m.Watches().Content, ShouldEqual, "共有2个通勤提醒：\n1. home：周末 08:00 从office到home\n2. home：工作日 18:00 从office到home\n")
+			So(commuteWatcher.Watches("commuter")[0].Mode, ShouldEqual, MapModeDriving)
+
+			m.Destination = "3"
+			So(m.Unwatch().Content, ShouldEqual, "没有第3个通勤提醒，使用map watches查看。")
+			m.Destination = "1"
+			So(m.Unwatch().Content, ShouldEqual, "已删除通勤提醒home。")
+			So(m.Watches().Content, ShouldEqual, "共有1个通勤提醒：\n1. home：工作日 18:00 从office到home\n")
+
+			m.Destination, m.Depart, m.Days = "home", "08:00", "weekends"
+			m.Watch()
+			So(m.Unwatch().Content, ShouldEqual, "已删除2个通勤提醒home。")
+		})
+	})
+}
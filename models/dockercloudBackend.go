@@ -0,0 +1,107 @@
+package models
+
+import "github.com/docker/go-dockercloud/dockercloud"
+
+// dockerCloudBackend runs the service commands against the Docker Cloud proxy.
+type dockerCloudBackend struct {
+	env *Environment
+}
+
+func (b dockerCloudBackend) ListServices() ([]ServiceInfo, error) {
+	services, err := getAllDockerCloudService(b.env)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]ServiceInfo, 0, len(services))
+	for _, service := range services {
+		infos = append(infos, dockerCloudServiceInfo(service))
+	}
+	return infos, nil
+}
+
+func (b dockerCloudBackend) GetService(name string) (ServiceInfo, error) {
+	dcList, err := getDockerCloudServiceByName(b.env, name)
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	if len(dcList.Objects) == 0 {
+		return ServiceInfo{}, ErrNotFound
+	}
+	return dockerCloudServiceInfo(dcList.Objects[0]), nil
+}
+
+func (b dockerCloudBackend) ListContainers(service string) ([]ContainerInfo, error) {
+	dcList, err := getDockerCloudServiceByName(b.env, service)
+	if err != nil {
+		return nil, err
+	}
+	if len(dcList.Objects) == 0 {
+		return nil, ErrNotFound
+	}
+	cList, err := getDockerCloudContainersByService(b.env, dcList.Objects[0].Uuid)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]ContainerInfo, 0, len(cList.Objects))
+	for _, container := range cList.Objects {
+		infos = append(infos, dockerCloudContainerInfo(container))
+	}
+	return infos, nil
+}
+
+func (b dockerCloudBackend) GetContainer(name string) (ContainerInfo, error) {
+	cList, err := getDockerCloudContainerByName(b.env, name)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	if len(cList.Objects) == 0 {
+		return ContainerInfo{}, ErrNotFound
+	}
+	return dockerCloudContainerInfo(cList.Objects[0]), nil
+}
+
+func (b dockerCloudBackend) ServiceAction(name string, action string) (string, error) {
+	if action != "start" && action != "stop" && action != "redeploy" {
+		return "", ErrNotSupported
+	}
+	_, actionURI, err := actionDockerCloudService(b.env, name, action)
+	return actionURI, err
+}
+
+func (b dockerCloudBackend) ScaleService(name string, replicas int) error {
+	return ErrNotSupported
+}
+
+func (b dockerCloudBackend) ServiceLogs(name string, lines int) (string, error) {
+	return "", ErrNotSupported
+}
+
+func dockerCloudServiceInfo(service dockercloud.Service) ServiceInfo {
+	return ServiceInfo{
+		Name:       service.Name,
+		State:      service.State,
+		Image:      service.Image_name,
+		Running:    service.Running_num_containers,
+		Stopped:    service.Stopped_num_containers,
+		Target:     service.Target_num_containers,
+		DeployedAt: service.Deployed_datetime,
+		StartedAt:  service.Started_datetime,
+		StoppedAt:  service.Stopped_datetime,
+	}
+}
+
+func dockerCloudContainerInfo(container dockercloud.Container) ContainerInfo {
+	return ContainerInfo{
+		Name:        container.Name,
+		State:       container.State,
+		Image:       container.Image_name,
+		ImageTag:    container.Image_tag,
+		Node:        resourceUuid(container.Node),
+		Service:     resourceUuid(container.Service),
+		PublicDNS:   container.Public_dns,
+		StartedAt:   container.Started_datetime,
+		StoppedAt:   container.Stopped_datetime,
+		ExitCode:    container.Exit_code,
+		ExitMessage: container.Exit_code_message,
+	}
+}
@@ -1,12 +1,15 @@
 package models
 
 import (
-	"crypto/tls"
 	"errors"
 	"fmt"
+	"net/url"
+	"path"
+	"sort"
 	"strings"
+	"time"
 
-	"github.com/astaxie/beego/httplib"
+	"github.com/astaxie/beego"
 	"github.com/docker/go-dockercloud/dockercloud"
 )
 
@@ -19,20 +22,72 @@ const (
 	DockerCloudHelpMsg      = `dockercloud is an operations tool.
 
 Usage:
-	dockercloud service NAME [status]|start|stop
+	dockercloud service [--state STATE] [--sort name|state] [PATTERN]
+	dockercloud service NAME [status]|start|stop|restart|redeploy
+	dockercloud service NAME scale N
+	dockercloud service NAME logs [LINES]
+	dockercloud container list SERVICE
+	dockercloud container inspect NAME
+	dockercloud stack list
+	dockercloud stack status|start|stop|redeploy NAME
+	dockercloud node list
+	dockercloud node status NAME
 or
-	dc service NAME [status]|start|stop
+	dc service [--state STATE] [--sort name|state] [PATTERN]
+	dc service NAME [status]|start|stop|restart|redeploy
+	dc service NAME scale N
+	dc service NAME logs [LINES]
+	dc container list SERVICE
+	dc container inspect NAME
+	dc stack list
+	dc stack status|start|stop|redeploy NAME
+	dc node list
+	dc node status NAME
+
+Service actions of the servicebackend of the environment:
+	engine: start, stop, restart, redeploy, scale, logs
+	dockercloud: start, stop, redeploy
 		
 Example 
-	dc service test status`
+	dc service --state Stopped web-*
+	dc service test status
+	dc service test scale 3
+	dc container list test
+	dc container inspect test-1
+	dc stack redeploy test
+	dc node status node-1`
+
+	DockerCloudActionHeader = "X-DockerCloud-Action-URI"
+)
+
+var (
+	dockerCloudActionNames = map[string]string{
+		"start":    "启动",
+		"stop":     "停止",
+		"restart":  "重启",
+		"redeploy": "重新部署",
+	}
+
+	dockerCloudActionTimeout      = time.Duration(beego.AppConfig.DefaultInt("actiontimeout", 600)) * time.Second
+	dockerCloudActionPollInterval = time.Duration(beego.AppConfig.DefaultInt("actionpollinterval", 5)) * time.Second
 )
 
 type DockerCloudTool struct {
 	toolBase
-	ServiceName string
-	Action      string
-	Privileged  bool
-	HelpMsg     string
+	Object        string // service (default), stack or node
+	UserID        string
+	Env           *Environment
+	ServiceName   string
+	ContainerName string
+	StackName     string
+	NodeName      string
+	StateFilter   string
+	SortBy        string // name (default) or state
+	Action        string
+	Replicas      int
+	Lines         int
+	Privileged    bool
+	HelpMsg       string
 }
 
 func (dc *DockerCloudTool) NewTool() {
@@ -42,126 +97,487 @@ func (dc *DockerCloudTool) NewTool() {
 	dc.HelpMsg = DockerCloudHelpMsg
 }
 
+// env is Env bound to the request. Actions are watched with Env itself, as
+// they outlive the request.
+func (dc *DockerCloudTool) env() *Environment {
+	return dc.Env.WithContext(dc.Context())
+}
+
 func (dc *DockerCloudTool) Run() (TextResponse, error) {
-	var dcList dockercloud.SListResponse
+	switch dc.Object {
+	case "stack":
+		return dc.runStack()
+	case "node":
+		return dc.runNode()
+	}
+
 	var textResp TextResponse
 	textResp.MsgType = MsgTypeText
 
+	backend, err := NewServiceBackend(dc.env())
+	if err != nil {
+		return textResp, err
+	}
+
 	switch dc.Action {
 	case "status":
 		if dc.ServiceName != "" {
-			if strings.ToLower(dc.ServiceName) == "all" {
-				var err error
-				dcList, err = getAllDockerCloudService()
+			if strings.ToLower(dc.ServiceName) == "all" || strings.ContainsAny(dc.ServiceName, "*?[") {
+				services, err := backend.ListServices()
 				if err != nil {
 					return textResp, err
 				}
-				textResp.Content = fmt.Sprintf("共有%d个服务。\n", dcList.Meta.TotalCount)
-				for i := 0; i < dcList.Meta.TotalCount; i++ {
-					textResp.Content += fmt.Sprintf("%d. %s: %s\n", i+1, dcList.Objects[i].Name, dcList.Objects[i].State)
-				}
-			} else {
-				var err error
-				dcList, err = getDockerCloudServiceByName(dc.ServiceName)
+				matched, err := dc.filterServices(services)
 				if err != nil {
 					return textResp, err
 				}
-				if dcList.Meta.TotalCount < 1 {
+				dc.sortServices(matched)
+				content := fmt.Sprintf("共有%d个服务。\n", len(services))
+				if len(matched) != len(services) {
+					content = fmt.Sprintf("共有%d个服务，符合条件的有%d个。\n", len(services), len(matched))
+				}
+				for i, service := range matched {
+					content += fmt.Sprintf("%d. %s: %s\n", i+1, service.Name, service.State)
+				}
+				textResp.Content = content
+			} else {
+				service, err := backend.GetService(dc.ServiceName)
+				if err == ErrNotFound {
 					textResp.Content = fmt.Sprintf("没有找到名称为%s的服务。", dc.ServiceName)
+				} else if err != nil {
+					return textResp, err
 				} else {
-					textResp.Content = fmt.Sprintf("%s: %s\n", dcList.Objects[0].Name, dcList.Objects[0].State)
+					textResp.Content = formatService(service)
 				}
 			}
 		}
-	case "start":
+	case "containers":
 		if dc.ServiceName != "" {
-			_, err := actionDockerCloudService(dc.ServiceName, "start")
-			if err != nil {
-				textResp.Content = fmt.Sprintf("服务启动错误，错误信息：%s\n", err.Error())
+			containers, err := backend.ListContainers(dc.ServiceName)
+			if err == ErrNotFound {
+				textResp.Content = fmt.Sprintf("没有找到名称为%s的服务。", dc.ServiceName)
+				break
+			} else if err != nil {
+				return textResp, err
+			}
+			textResp.Content = fmt.Sprintf("服务%s共有%d个容器。\n", dc.ServiceName, len(containers))
+			for i, container := range containers {
+				textResp.Content += fmt.Sprintf("%d. %s: %s\n", i+1, container.Name, container.State)
+				textResp.Content += fmt.Sprintf("    节点：%s\n", container.Node)
+				textResp.Content += fmt.Sprintf("    退出码：%d\n", container.ExitCode)
+				textResp.Content += fmt.Sprintf("    启动时间：%s\n", container.StartedAt)
+				textResp.Content += fmt.Sprintf("    镜像标签：%s\n", container.ImageTag)
+			}
+		}
+	case "inspect":
+		if dc.ContainerName != "" {
+			container, err := backend.GetContainer(dc.ContainerName)
+			if err == ErrNotFound {
+				textResp.Content = fmt.Sprintf("没有找到名称为%s的容器。", dc.ContainerName)
+			} else if err != nil {
+				return textResp, err
 			} else {
-				textResp.Content = fmt.Sprintf("服务启动成功，请稍后查看该服务状态。")
+				textResp.Content = formatContainer(container)
 			}
 		}
-	case "stop":
+	case "start", "stop", "restart", "redeploy":
 		if dc.ServiceName != "" {
-			_, err := actionDockerCloudService(dc.ServiceName, "stop")
+			actionURI, err := backend.ServiceAction(dc.ServiceName, dc.Action)
+			textResp.Content = dc.actionReply("服务", dc.ServiceName, actionURI, err)
+		}
+	case "scale":
+		if dc.ServiceName != "" {
+			err := backend.ScaleService(dc.ServiceName, dc.Replicas)
 			if err != nil {
-				textResp.Content = fmt.Sprintf("服务错误，错误信息：%s\n", err.Error())
+				textResp.Content = fmt.Sprintf("服务扩缩容错误，错误信息：%s\n", ErrorMessage(err))
 			} else {
-				textResp.Content = fmt.Sprintf("服务停止成功，请稍后查看该服务状态。")
+				textResp.Content = fmt.Sprintf("服务%s已调整为%d个容器，请稍后查看该服务状态。", dc.ServiceName, dc.Replicas)
 			}
 		}
-	case "redeploy":
+	case "logs":
 		if dc.ServiceName != "" {
-			_, err := actionDockerCloudService(dc.ServiceName, "redeploy")
+			logs, err := backend.ServiceLogs(dc.ServiceName, dc.Lines)
+			if err == ErrNotFound {
+				textResp.Content = fmt.Sprintf("没有找到名称为%s的服务。", dc.ServiceName)
+			} else if err != nil {
+				textResp.Content = fmt.Sprintf("获取服务日志错误，错误信息：%s\n", ErrorMessage(err))
+			} else if logs == "" {
+				textResp.Content = fmt.Sprintf("服务%s没有日志。", dc.ServiceName)
+			} else {
+				textResp.Content = logs
+			}
+		}
+	default:
+		return textResp, errors.New("Invalid Action. Valid actions are 'start', 'stop', 'restart', 'redeploy', 'scale', 'logs', 'status', 'containers' and 'inspect'")
+	}
+	return textResp, nil
+}
+
+// actionReply builds the reply of a start, stop or redeploy action. When the
+// action can be tracked, its progress is polled in the background and the
+// final outcome is pushed to the user.
+func (dc *DockerCloudTool) actionReply(object string, name string, actionURI string, err error) string {
+	verb := dockerCloudActionNames[dc.Action]
+	if err != nil {
+		return fmt.Sprintf("%s%s错误，错误信息：%s\n", object, verb, ErrorMessage(err))
+	}
+	if actionURI == "" || dc.UserID == "" {
+		return fmt.Sprintf("%s%s成功，请稍后查看该%s状态。", object, verb, object)
+	}
+	go watchDockerCloudAction(dc.Env, actionURI, dc.UserID, fmt.Sprintf("[%s] %s%s%s", dc.Env.Name, object, name, verb))
+	return fmt.Sprintf("%s%s已提交，完成后将推送最终结果。", object, verb)
+}
+
+// watchDockerCloudAction polls the action until it reaches a final state or
+// times out, then pushes the outcome to the user.
+func watchDockerCloudAction(env *Environment, actionURI string, userID string, subject string) {
+	var action dockercloud.Action
+	var err error
+	start := time.Now()
+	uuid := resourceUuid(actionURI)
+
+	for time.Since(start) < dockerCloudActionTimeout {
+		time.Sleep(dockerCloudActionPollInterval)
+		action, err = getDockerCloudAction(env, uuid)
+		if err != nil {
+			beego.Warn("Failed to get dockercloud action:", uuid, err.Error())
+			continue
+		}
+		if action.State == "Success" || action.State == "Failed" || action.State == "Canceled" {
+			break
+		}
+	}
+
+	var content string
+	switch action.State {
+	case "Success":
+		content = fmt.Sprintf("%s成功，用时%s。", subject, dockerCloudActionDuration(action, start))
+	case "Failed":
+		content = fmt.Sprintf("%s失败，用时%s。", subject, dockerCloudActionDuration(action, start))
+	case "Canceled":
+		content = fmt.Sprintf("%s已取消。", subject)
+	default:
+		content = fmt.Sprintf("%s超时，%s后仍未完成，当前状态：%s。", subject, dockerCloudActionTimeout, action.State)
+	}
+	beego.Info("Dockercloud action finished. User:", userID, "Action:", uuid, "State:", action.State)
+	if err := PushText(userID, content); err != nil {
+		beego.Error("Failed to push dockercloud action result. User:", userID, "Error:", err.Error())
+	}
+}
+
+// dockerCloudActionDuration prefers the dates reported by Docker Cloud and
+// falls back to the time spent polling.
+func dockerCloudActionDuration(action dockercloud.Action, start time.Time) time.Duration {
+	startDate, err1 := time.Parse(time.RFC1123Z, action.Start_date)
+	endDate, err2 := time.Parse(time.RFC1123Z, action.End_date)
+	if err1 != nil || err2 != nil {
+		return time.Since(start).Round(time.Second)
+	}
+	return endDate.Sub(startDate)
+}
+
+func (dc *DockerCloudTool) runStack() (TextResponse, error) {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+
+	switch dc.Action {
+	case "list":
+		stackList, err := getAllDockerCloudStack(dc.env())
+		if err != nil {
+			return textResp, err
+		}
+		textResp.Content = fmt.Sprintf("共有%d个栈。\n", len(stackList.Objects))
+		for i, stack := range stackList.Objects {
+			textResp.Content += fmt.Sprintf("%d. %s: %s\n", i+1, stack.Name, stack.State)
+		}
+	case "status":
+		if dc.StackName != "" {
+			stackList, err := getDockerCloudStackByName(dc.env(), dc.StackName)
 			if err != nil {
-				textResp.Content = fmt.Sprintf("服务重新部署错误，错误信息：%s\n", err.Error())
+				return textResp, err
+			}
+			if len(stackList.Objects) == 0 {
+				textResp.Content = fmt.Sprintf("没有找到名称为%s的栈。", dc.StackName)
 			} else {
-				textResp.Content = fmt.Sprintf("服务重新部署成功，请稍后查看该服务状态。")
+				// the stack lists the uris of its services, name them
+				services, err := getAllDockerCloudService(dc.env())
+				if err != nil {
+					beego.Warn("Failed to get the services of the stack. Stack:", dc.StackName, "Error:", err)
+				}
+				textResp.Content = formatDockerCloudStack(stackList.Objects[0], services)
 			}
 		}
+	case "start", "stop", "redeploy":
+		if dc.StackName != "" {
+			_, actionURI, err := actionDockerCloudStack(dc.env(), dc.StackName, dc.Action)
+			textResp.Content = dc.actionReply("栈", dc.StackName, actionURI, err)
+		}
 	default:
-		return textResp, errors.New("Invalid Action. Valid actions are 'start', 'stop' and 'status'")
+		return textResp, errors.New("Invalid Action. Valid stack actions are 'list', 'status', 'start', 'stop' and 'redeploy'")
 	}
 	return textResp, nil
 }
 
-func getAllDockerCloudService() (dockercloud.SListResponse, error) {
+func (dc *DockerCloudTool) runNode() (TextResponse, error) {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+
+	switch dc.Action {
+	case "list":
+		nodeList, err := getAllDockerCloudNode(dc.env())
+		if err != nil {
+			return textResp, err
+		}
+		textResp.Content = fmt.Sprintf("共有%d个节点。\n", len(nodeList.Objects))
+		for i, node := range nodeList.Objects {
+			textResp.Content += fmt.Sprintf("%d. %s: %s\n", i+1, dockerCloudNodeName(node), node.State)
+		}
+	case "status":
+		if dc.NodeName != "" {
+			nodeList, err := getDockerCloudNodeByName(dc.env(), dc.NodeName)
+			if err != nil {
+				return textResp, err
+			}
+			if len(nodeList.Objects) == 0 {
+				textResp.Content = fmt.Sprintf("没有找到名称为%s的节点。", dc.NodeName)
+			} else {
+				textResp.Content = formatDockerCloudNode(nodeList.Objects[0])
+			}
+		}
+	default:
+		return textResp, errors.New("Invalid Action. Valid node actions are 'list' and 'status'")
+	}
+	return textResp, nil
+}
+
+// filterServices keeps the services matching the state filter and the name
+// pattern, if any.
+func (dc *DockerCloudTool) filterServices(services []ServiceInfo) ([]ServiceInfo, error) {
+	var matched []ServiceInfo
+	for _, service := range services {
+		if dc.StateFilter != "" && !strings.EqualFold(service.State, dc.StateFilter) {
+			continue
+		}
+		if strings.ToLower(dc.ServiceName) != "all" {
+			ok, err := path.Match(dc.ServiceName, service.Name)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		matched = append(matched, service)
+	}
+	return matched, nil
+}
+
+// sortServices sorts by name unless sorting by state is asked, services
+// in the same state stay sorted by name.
+func (dc *DockerCloudTool) sortServices(services []ServiceInfo) {
+	sort.SliceStable(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	if dc.SortBy == "state" {
+		sort.SliceStable(services, func(i, j int) bool {
+			return services[i].State < services[j].State
+		})
+	}
+}
+
+// getAllDockerCloudService follows the pagination of the service list
+// until every service is fetched.
+func getAllDockerCloudService(env *Environment) ([]dockercloud.Service, error) {
+	var services []dockercloud.Service
+	offset := "0"
+	for {
+		var dcList dockercloud.SListResponse
+		err := env.Client.Get(DockerCloudToolEndpoint+"/service", url.Values{"offset": {offset}}, &dcList)
+		if err != nil {
+			return services, err
+		}
+		services = append(services, dcList.Objects...)
+
+		if dcList.Meta.Next == "" || len(dcList.Objects) == 0 {
+			break
+		}
+		next, err := url.Parse(dcList.Meta.Next)
+		if err != nil {
+			return services, err
+		}
+		offset = next.Query().Get("offset")
+		if offset == "" {
+			break
+		}
+	}
+	return services, nil
+}
+
+func getDockerCloudServiceByName(env *Environment, name string) (dockercloud.SListResponse, error) {
 	var dcList dockercloud.SListResponse
-	req := httplib.Get(APIADDRESS + APIVERSION + DockerCloudToolEndpoint + "/service")
-	req.SetBasicAuth(apiuser, apipassword)
-	req.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-	err := req.ToJSON(&dcList)
+	err := env.Client.Get(DockerCloudToolEndpoint+"/service/"+name, nil, &dcList)
 	if err != nil {
 		return dcList, err
 	}
 	return dcList, nil
 }
 
-func getDockerCloudServiceByName(name string) (dockercloud.SListResponse, error) {
-	var dcList dockercloud.SListResponse
-	req := httplib.Get(APIADDRESS + APIVERSION + DockerCloudToolEndpoint + "/service/" + name)
-	req.SetBasicAuth(apiuser, apipassword)
-	req.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-	err := req.ToJSON(&dcList)
+func getDockerCloudContainersByService(env *Environment, serviceUuid string) (dockercloud.CListResponse, error) {
+	var cList dockercloud.CListResponse
+	err := env.Client.Get(DockerCloudToolEndpoint+"/container", url.Values{"service": {serviceUuid}}, &cList)
 	if err != nil {
-		return dcList, err
+		return cList, err
 	}
-	return dcList, nil
+	return cList, nil
 }
 
-func getDockerCloudServiceUuid(name string) (string, error) {
-	dcList, err := getDockerCloudServiceByName(name)
+func getDockerCloudContainerByName(env *Environment, name string) (dockercloud.CListResponse, error) {
+	var cList dockercloud.CListResponse
+	err := env.Client.Get(DockerCloudToolEndpoint+"/container/"+name, nil, &cList)
+	if err != nil {
+		return cList, err
+	}
+	return cList, nil
+}
+
+func getDockerCloudServiceUuid(env *Environment, name string) (string, error) {
+	dcList, err := getDockerCloudServiceByName(env, name)
 	if err != nil {
 		return "", err
 	}
-	if dcList.Meta.TotalCount < 1 {
+	if len(dcList.Objects) == 0 {
 		return "", fmt.Errorf("没有找到名称为%s的服务。", name)
 	}
 	return dcList.Objects[0].Uuid, nil
 }
 
-// start, stop and redeploy service
-func actionDockerCloudService(name string, action string) (dockercloud.Service, error) {
+// start, stop and redeploy service, returns the uri of the action tracking it
+func actionDockerCloudService(env *Environment, name string, action string) (dockercloud.Service, string, error) {
 	var service dockercloud.Service
-	var req *httplib.BeegoHTTPRequest
-	uuid, err := getDockerCloudServiceUuid(name)
+	uuid, err := getDockerCloudServiceUuid(env, name)
 	if err != nil {
-		return service, err
+		return service, "", err
 	}
 	switch action {
-	case "start":
-		req = httplib.Post(APIADDRESS + APIVERSION + DockerCloudToolEndpoint + "/service/" + uuid + "/start")
-	case "stop":
-		req = httplib.Post(APIADDRESS + APIVERSION + DockerCloudToolEndpoint + "/service/" + uuid + "/stop")
-	case "redeploy":
-		req = httplib.Post(APIADDRESS + APIVERSION + DockerCloudToolEndpoint + "/service/" + uuid + "/redeploy")
-	}
-	req.SetBasicAuth(apiuser, apipassword)
-	req.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-	err = req.ToJSON(&service)
+	case "start", "stop", "redeploy":
+	default:
+		return service, "", fmt.Errorf("unknown service action: %s", action)
+	}
+	header, err := env.Client.Post(DockerCloudToolEndpoint+"/service/"+uuid+"/"+action, nil, &service)
 	if err != nil {
-		return service, err
+		return service, "", err
+	}
+	return service, header.Get(DockerCloudActionHeader), nil
+}
+
+func getAllDockerCloudStack(env *Environment) (dockercloud.StackListResponse, error) {
+	var stackList dockercloud.StackListResponse
+	err := env.Client.Get(DockerCloudToolEndpoint+"/stack", nil, &stackList)
+	if err != nil {
+		return stackList, err
+	}
+	return stackList, nil
+}
+
+func getDockerCloudStackByName(env *Environment, name string) (dockercloud.StackListResponse, error) {
+	var stackList dockercloud.StackListResponse
+	err := env.Client.Get(DockerCloudToolEndpoint+"/stack/"+name, nil, &stackList)
+	if err != nil {
+		return stackList, err
+	}
+	return stackList, nil
+}
+
+// start, stop and redeploy stack, returns the uri of the action tracking it
+func actionDockerCloudStack(env *Environment, name string, action string) (dockercloud.Stack, string, error) {
+	var stack dockercloud.Stack
+	stackList, err := getDockerCloudStackByName(env, name)
+	if err != nil {
+		return stack, "", err
+	}
+	if len(stackList.Objects) == 0 {
+		return stack, "", fmt.Errorf("没有找到名称为%s的栈。", name)
+	}
+	header, err := env.Client.Post(DockerCloudToolEndpoint+"/stack/"+stackList.Objects[0].Uuid+"/"+action, nil, &stack)
+	if err != nil {
+		return stack, "", err
+	}
+	return stack, header.Get(DockerCloudActionHeader), nil
+}
+
+func getDockerCloudAction(env *Environment, uuid string) (dockercloud.Action, error) {
+	var action dockercloud.Action
+	err := env.Client.Get(DockerCloudToolEndpoint+"/action/"+uuid, nil, &action)
+	if err != nil {
+		return action, err
+	}
+	return action, nil
+}
+
+func getAllDockerCloudNode(env *Environment) (dockercloud.NodeListResponse, error) {
+	var nodeList dockercloud.NodeListResponse
+	err := env.Client.Get(DockerCloudToolEndpoint+"/node", nil, &nodeList)
+	if err != nil {
+		return nodeList, err
+	}
+	return nodeList, nil
+}
+
+// getDockerCloudNodeByName looks up a node by nickname, fqdn or uuid.
+func getDockerCloudNodeByName(env *Environment, name string) (dockercloud.NodeListResponse, error) {
+	var nodeList dockercloud.NodeListResponse
+	err := env.Client.Get(DockerCloudToolEndpoint+"/node/"+name, nil, &nodeList)
+	if err != nil {
+		return nodeList, err
+	}
+	return nodeList, nil
+}
+
+// resourceUuid returns the uuid part of a Docker Cloud resource uri,
+// e.g. /api/infra/v1/node/<uuid>/
+func resourceUuid(uri string) string {
+	parts := strings.Split(strings.Trim(uri, "/"), "/")
+	return parts[len(parts)-1]
+}
+
+// formatDockerCloudStack shows the stack with the names and states of its
+// services found in services, else their uuids.
+func formatDockerCloudStack(stack dockercloud.Stack, services []dockercloud.Service) string {
+	byURI := make(map[string]dockercloud.Service)
+	for _, service := range services {
+		byURI[resourceUuid(service.Resource_uri)] = service
+	}
+	content := fmt.Sprintf("栈：%s\n", stack.Name)
+	content += fmt.Sprintf("状态：%s\n", stack.State)
+	content += fmt.Sprintf("服务：%d个\n", len(stack.Services))
+	for _, uri := range stack.Services {
+		if service, ok := byURI[resourceUuid(uri)]; ok {
+			content += fmt.Sprintf("    %s: %s\n", service.Name, service.State)
+		} else {
+			content += fmt.Sprintf("    %s\n", resourceUuid(uri))
+		}
+	}
+	content += fmt.Sprintf("部署时间：%s\n", stack.Deployed_datetime)
+	return content
+}
+
+func formatDockerCloudNode(node dockercloud.Node) string {
+	content := fmt.Sprintf("节点：%s\n", dockerCloudNodeName(node))
+	content += fmt.Sprintf("状态：%s\n", node.State)
+	content += fmt.Sprintf("CPU：%d核\n", node.Cpu)
+	content += fmt.Sprintf("内存：%dMB\n", node.Memory)
+	content += fmt.Sprintf("磁盘：%dGB\n", node.Disk)
+	content += fmt.Sprintf("容器：%d个\n", node.Current_num_containers)
+	content += fmt.Sprintf("集群：%s\n", resourceUuid(node.Node_cluster))
+	content += fmt.Sprintf("地址：%s (%s)\n", node.External_fqdn, node.Public_ip)
+	content += fmt.Sprintf("Docker版本：%s\n", node.Docker_version)
+	content += fmt.Sprintf("最后在线：%s\n", node.Last_seen)
+	return content
+}
+
+func dockerCloudNodeName(node dockercloud.Node) string {
+	if node.Nickname != "" {
+		return node.Nickname
 	}
-	return service, nil
+	return node.External_fqdn
 }
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newFakeDockerCloud serves the responses by the path under the docker cloud
+// endpoint, e.g. stack/web.
+func newFakeDockerCloud(responses map[string]string) (*Environment, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[strings.TrimPrefix(r.URL.Path, "/v1"+DockerCloudToolEndpoint+"/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	env := &Environment{Name: "dockercloudtest", APIAddress: server.URL + "/", APITimeout: time.Second}
+	client, err := NewAPIClient(env)
+	if err != nil {
+		panic(err)
+	}
+	env.Client = client
+	return env, server.Close
+}
+
+func TestDockerCloudStack(t *testing.T) {
+	Convey("Subject: Status of a Docker Cloud stack\n", t, func() {
+		env, done := newFakeDockerCloud(map[string]string{
+			"stack/web": `{"meta": {"total_count": 1}, "objects": [{"name": "web", "state": "Running",
+				"services": ["/api/app/v1/service/uuid-nginx/", "/api/app/v1/service/uuid-gone/"]}]}`,
+			"service": `{"meta": {"total_count": 1}, "objects": [{"name": "nginx", "state": "Running",
+				"resource_uri": "/api/app/v1/service/uuid-nginx/"}]}`,
+		})
+		defer done()
+		var dc DockerCloudTool
+		dc.NewTool()
+		dc.Env, dc.Object, dc.Action, dc.StackName = env, "stack", "status", "web"
+
+		resp, err := dc.Run()
+		So(err, ShouldBeNil)
+		So(resp.Content, ShouldContainSubstring, "服务：2个\n    nginx: Running\n    uuid-gone\n")
+	})
+}
+
+func TestDockerCloudEmptyObjects(t *testing.T) {
+	Convey("Subject: A total count without objects is not found\n", t, func() {
+		empty := `{"meta": {"total_count": 1}, "objects": null}`
+		env, done := newFakeDockerCloud(map[string]string{
+			"service/web": empty, "container/web-1": empty, "stack/web": empty, "node/node-1": empty,
+		})
+		defer done()
+		backend := dockerCloudBackend{env: env}
+
+		_, err := backend.GetService("web")
+		So(err, ShouldEqual, ErrNotFound)
+		_, err = backend.ListContainers("web")
+		So(err, ShouldEqual, ErrNotFound)
+		_, err = backend.GetContainer("web-1")
+		So(err, ShouldEqual, ErrNotFound)
+		_, _, err = actionDockerCloudService(env, "web", "stop")
+		So(err.Error(), ShouldEqual, "没有找到名称为web的服务。")
+		_, _, err = actionDockerCloudStack(env, "web", "stop")
+		So(err.Error(), ShouldEqual, "没有找到名称为web的栈。")
+
+		var dc DockerCloudTool
+		dc.NewTool()
+		dc.Env, dc.Object, dc.Action, dc.StackName = env, "stack", "status", "web"
+		resp, err := dc.Run()
+		So(err, ShouldBeNil)
+		So(resp.Content, ShouldEqual, "没有找到名称为web的栈。")
+		dc.Object, dc.NodeName = "node", "node-1"
+		resp, err = dc.Run()
+		So(err, ShouldBeNil)
+		So(resp.Content, ShouldEqual, "没有找到名称为node-1的节点。")
+	})
+}
@@ -0,0 +1,520 @@
+package models
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/astaxie/beego/httplib"
+)
+
+const (
+	EngineAPIVersion = "v1.29"
+
+	// label keeping the replicas of a stopped service, so start can restore them
+	engineReplicasLabel = "com.xzdbd.ops-angel.replicas"
+)
+
+// EngineBackend runs the service commands directly against a Docker Engine
+// in swarm mode. Services are swarm services and their containers are the
+// tasks of the service.
+type EngineBackend struct {
+	baseURL   string
+	transport http.RoundTripper
+	// breaker fails requests fast while the engine is unhealthy
+	breaker *CircuitBreaker
+	// ctx cancels the requests of a backend made by withContext
+	ctx context.Context
+}
+
+type engineService struct {
+	ID      string
+	Version struct {
+		Index uint64
+	}
+	CreatedAt string
+	UpdatedAt string
+	Spec      struct {
+		Name         string
+		Labels       map[string]string
+		TaskTemplate struct {
+			ContainerSpec struct {
+				Image string
+			}
+		}
+		Mode struct {
+			Replicated *struct {
+				Replicas uint64
+			}
+			Global *struct{}
+		}
+	}
+}
+
+type engineTask struct {
+	ID           string
+	ServiceID    string
+	NodeID       string
+	Slot         int
+	DesiredState string
+	Spec         struct {
+		ContainerSpec struct {
+			Image string
+		}
+	}
+	Status struct {
+		Timestamp       string
+		State           string
+		Err             string
+		ContainerStatus struct {
+			ContainerID string
+			ExitCode    int
+		}
+	}
+}
+
+type engineContainer struct {
+	ID      string `json:"Id"`
+	Name    string
+	Created string
+	State   struct {
+		Status     string
+		ExitCode   int
+		Error      string
+		StartedAt  string
+		FinishedAt string
+	}
+	Config struct {
+		Image  string
+		Labels map[string]string
+	}
+}
+
+type engineNode struct {
+	ID          string
+	Description struct {
+		Hostname string
+	}
+}
+
+type engineError struct {
+	Message string `json:"message"`
+}
+
+// NewEngineBackend connects to the Docker Engine at address, which is either
+// a unix socket such as unix:///var/run/docker.sock or tcp://host:port.
+// tlsConfig is only used by tcp addresses, plain http is used when it is nil.
+func NewEngineBackend(address string, tlsConfig *tls.Config) (*EngineBackend, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	// the transport is kept with the backend, built like the api transport so
+	// httplib leaves it as is
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		dialer := net.Dialer{Timeout: DefaultAPITimeout}
+		transport := newAPITransport(nil, DefaultAPITimeout)
+		// no proxy for the socket, but set so httplib does not write it
+		transport.Proxy = func(*http.Request) (*url.URL, error) { return nil, nil }
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		transport.Dial = func(_, _ string) (net.Conn, error) {
+			return dialer.Dial("unix", socket)
+		}
+		return &EngineBackend{baseURL: "http://docker/" + EngineAPIVersion, transport: transport,
+			breaker: GetCircuitBreaker(address)}, nil
+	case "tcp", "http", "https":
+		scheme := "http"
+		if tlsConfig != nil {
+			scheme = "https"
+		}
+		transport := newAPITransport(tlsConfig, DefaultAPITimeout)
+		return &EngineBackend{baseURL: scheme + "://" + u.Host + "/" + EngineAPIVersion, transport: transport,
+			breaker: GetCircuitBreaker(address)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported engine address: %s", address)
+	}
+}
+
+func (e *EngineBackend) ListServices() ([]ServiceInfo, error) {
+	var services []engineService
+	if err := e.do(e.request("GET", "/services"), &services); err != nil {
+		return nil, err
+	}
+	running, err := e.runningTasks("")
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]ServiceInfo, 0, len(services))
+	for _, service := range services {
+		infos = append(infos, engineServiceInfo(service, running[service.ID]))
+	}
+	return infos, nil
+}
+
+func (e *EngineBackend) GetService(name string) (ServiceInfo, error) {
+	service, err := e.inspectService(name)
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	running, err := e.runningTasks(service.ID)
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	return engineServiceInfo(service, running[service.ID]), nil
+}
+
+func (e *EngineBackend) ListContainers(service string) ([]ContainerInfo, error) {
+	s, err := e.inspectService(service)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []engineTask
+	req := e.request("GET", "/tasks")
+	req.Param("filters", fmt.Sprintf(`{"service":[%q],"desired-state":["running"]}`, s.ID))
+	if err := e.do(req, &tasks); err != nil {
+		return nil, err
+	}
+	nodes, err := e.nodeNames()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]ContainerInfo, 0, len(tasks))
+	for _, task := range tasks {
+		image, tag := splitImage(task.Spec.ContainerSpec.Image)
+		info := ContainerInfo{
+			Name:        fmt.Sprintf("%s.%d", s.Spec.Name, task.Slot),
+			State:       task.Status.State,
+			Image:       image,
+			ImageTag:    tag,
+			Node:        nodes[task.NodeID],
+			Service:     s.Spec.Name,
+			StartedAt:   task.Status.Timestamp,
+			ExitCode:    task.Status.ContainerStatus.ExitCode,
+			ExitMessage: task.Status.Err,
+		}
+		if info.Node == "" {
+			info.Node = task.NodeID
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// GetContainer inspects a container of the engine the backend connects to.
+func (e *EngineBackend) GetContainer(name string) (ContainerInfo, error) {
+	var container engineContainer
+	if err := e.do(e.request("GET", "/containers/"+url.PathEscape(name)+"/json"), &container); err != nil {
+		return ContainerInfo{}, err
+	}
+	image, tag := splitImage(container.Config.Image)
+	info := ContainerInfo{
+		Name:        strings.TrimPrefix(container.Name, "/"),
+		State:       container.State.Status,
+		Image:       image,
+		ImageTag:    tag,
+		Node:        container.Config.Labels["com.docker.swarm.node.id"],
+		Service:     container.Config.Labels["com.docker.swarm.service.name"],
+		StartedAt:   container.State.StartedAt,
+		ExitCode:    container.State.ExitCode,
+		ExitMessage: container.State.Error,
+	}
+	if container.State.Status != "running" {
+		info.StoppedAt = container.State.FinishedAt
+	}
+	return info, nil
+}
+
+// ServiceAction emulates start and stop by scaling the service, restart and
+// redeploy force the update of the service. It runs synchronously.
+func (e *EngineBackend) ServiceAction(name string, action string) (string, error) {
+	switch action {
+	case "start":
+		return "", e.updateService(name, func(spec map[string]interface{}) error {
+			replicas := 1
+			labels := specMap(spec, "Labels")
+			if saved, ok := labels[engineReplicasLabel].(string); ok {
+				if n, err := strconv.Atoi(saved); err == nil && n > 0 {
+					replicas = n
+				}
+				delete(labels, engineReplicasLabel)
+			}
+			return setReplicas(spec, replicas)
+		})
+	case "stop":
+		return "", e.updateService(name, func(spec map[string]interface{}) error {
+			replicas, err := getReplicas(spec)
+			if err != nil {
+				return err
+			}
+			if replicas > 0 {
+				specMap(spec, "Labels")[engineReplicasLabel] = strconv.Itoa(replicas)
+			}
+			return setReplicas(spec, 0)
+		})
+	case "restart", "redeploy":
+		return "", e.updateService(name, func(spec map[string]interface{}) error {
+			template := specMap(spec, "TaskTemplate")
+			forceUpdate, _ := template["ForceUpdate"].(float64)
+			template["ForceUpdate"] = forceUpdate + 1
+			return nil
+		})
+	default:
+		return "", ErrNotSupported
+	}
+}
+
+func (e *EngineBackend) ScaleService(name string, replicas int) error {
+	return e.updateService(name, func(spec map[string]interface{}) error {
+		return setReplicas(spec, replicas)
+	})
+}
+
+// ServiceLogs returns the last lines of the logs of every task of the service.
+func (e *EngineBackend) ServiceLogs(name string, lines int) (string, error) {
+	service, err := e.inspectService(name)
+	if err != nil {
+		return "", err
+	}
+	req := e.request("GET", "/services/"+service.ID+"/logs")
+	req.Param("stdout", "1")
+	req.Param("stderr", "1")
+	req.Param("tail", strconv.Itoa(lines))
+	var logs []byte
+	if err := e.do(req, &logs); err != nil {
+		return "", err
+	}
+	return demuxEngineLogs(logs), nil
+}
+
+func (e *EngineBackend) inspectService(name string) (engineService, error) {
+	var service engineService
+	err := e.do(e.request("GET", "/services/"+url.PathEscape(name)), &service)
+	return service, err
+}
+
+// runningTasks counts the running tasks per service id, of all services
+// when serviceID is empty.
+func (e *EngineBackend) runningTasks(serviceID string) (map[string]int, error) {
+	var tasks []engineTask
+	req := e.request("GET", "/tasks")
+	if serviceID != "" {
+		req.Param("filters", fmt.Sprintf(`{"service":[%q],"desired-state":["running"]}`, serviceID))
+	} else {
+		req.Param("filters", `{"desired-state":["running"]}`)
+	}
+	if err := e.do(req, &tasks); err != nil {
+		return nil, err
+	}
+	running := make(map[string]int)
+	for _, task := range tasks {
+		if task.Status.State == "running" {
+			running[task.ServiceID]++
+		}
+	}
+	return running, nil
+}
+
+func (e *EngineBackend) nodeNames() (map[string]string, error) {
+	var nodes []engineNode
+	if err := e.do(e.request("GET", "/nodes"), &nodes); err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, node := range nodes {
+		names[node.ID] = node.Description.Hostname
+	}
+	return names, nil
+}
+
+// updateService fetches the spec of the service, lets update modify it and
+// posts it back. The spec is kept as a map so that fields unknown to the
+// backend survive the update.
+func (e *EngineBackend) updateService(name string, update func(spec map[string]interface{}) error) error {
+	var service struct {
+		ID      string
+		Version struct {
+			Index uint64
+		}
+		Spec map[string]interface{}
+	}
+	if err := e.do(e.request("GET", "/services/"+url.PathEscape(name)), &service); err != nil {
+		return err
+	}
+	if err := update(service.Spec); err != nil {
+		return err
+	}
+	req := e.request("POST", "/services/"+service.ID+"/update?version="+strconv.FormatUint(service.Version.Index, 10))
+	if _, err := req.JSONBody(service.Spec); err != nil {
+		return err
+	}
+	return e.do(req, nil)
+}
+
+func (e *EngineBackend) request(method string, path string) *httplib.BeegoHTTPRequest {
+	req := httplib.NewBeegoRequest(e.baseURL+path, method)
+	if e.ctx != nil {
+		withRequestContext(req, e.ctx)
+	}
+	req.SetTransport(e.transport)
+	return req
+}
+
+// withContext returns a copy of the backend whose requests stop when ctx is
+// done, sharing the connections of e.
+func (e *EngineBackend) withContext(ctx context.Context) *EngineBackend {
+	backend := *e
+	backend.ctx = ctx
+	return &backend
+}
+
+// do sends the request and decodes the JSON response into v, or stores the
+// raw body when v is a *[]byte. Engine errors are returned with their message
+// and a 404 is returned as ErrNotFound.
+func (e *EngineBackend) do(req *httplib.BeegoHTTPRequest, v interface{}) error {
+	if e.breaker == nil {
+		return e.send(req, v)
+	}
+	if err := e.breaker.Allow(); err != nil {
+		return err
+	}
+	err := e.send(req, v)
+	e.breaker.RecordContext(req.GetRequest().Context(), err)
+	return err
+}
+
+// send is do without the breaker. Server errors are returned as
+// *APIStatusError so they count as failures of the engine.
+func (e *EngineBackend) send(req *httplib.BeegoHTTPRequest, v interface{}) error {
+	resp, err := req.Response()
+	if err != nil {
+		return err
+	}
+	body, err := req.Bytes()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var engineErr engineError
+		message := resp.Status
+		if json.Unmarshal(body, &engineErr) == nil && engineErr.Message != "" {
+			message = engineErr.Message
+		}
+		if resp.StatusCode >= 500 {
+			return &APIStatusError{Method: req.GetRequest().Method, URL: req.GetRequest().URL.String(),
+				StatusCode: resp.StatusCode, Body: message}
+		}
+		return fmt.Errorf("engine error: %s", message)
+	}
+	switch out := v.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*out = body
+		return nil
+	default:
+		return json.Unmarshal(body, v)
+	}
+}
+
+func engineServiceInfo(service engineService, running int) ServiceInfo {
+	target := running
+	if service.Spec.Mode.Replicated != nil {
+		target = int(service.Spec.Mode.Replicated.Replicas)
+	}
+	stopped := target - running
+	if stopped < 0 {
+		stopped = 0
+	}
+
+	var state string
+	switch {
+	case target == 0:
+		state = "Stopped"
+	case running == 0:
+		state = "Not running"
+	case running < target:
+		state = "Partly running"
+	default:
+		state = "Running"
+	}
+
+	return ServiceInfo{
+		Name:       service.Spec.Name,
+		State:      state,
+		Image:      service.Spec.TaskTemplate.ContainerSpec.Image,
+		Running:    running,
+		Stopped:    stopped,
+		Target:     target,
+		DeployedAt: service.CreatedAt,
+		StartedAt:  service.UpdatedAt,
+	}
+}
+
+func specMap(spec map[string]interface{}, key string) map[string]interface{} {
+	m, ok := spec[key].(map[string]interface{})
+	if !ok {
+		m = make(map[string]interface{})
+		spec[key] = m
+	}
+	return m
+}
+
+func getReplicas(spec map[string]interface{}) (int, error) {
+	replicated, ok := specMap(spec, "Mode")["Replicated"].(map[string]interface{})
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	replicas, _ := replicated["Replicas"].(float64)
+	return int(replicas), nil
+}
+
+// setReplicas fails on global services, which can't be scaled.
+func setReplicas(spec map[string]interface{}, replicas int) error {
+	replicated, ok := specMap(spec, "Mode")["Replicated"].(map[string]interface{})
+	if !ok {
+		return ErrNotSupported
+	}
+	replicated["Replicas"] = replicas
+	return nil
+}
+
+// splitImage splits name:tag@digest into the name and the tag.
+func splitImage(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// demuxEngineLogs strips the 8 bytes frame headers the engine puts in front of
+// every chunk of stdout or stderr when the service has no tty.
+func demuxEngineLogs(data []byte) string {
+	var logs []byte
+	for len(data) >= 8 && data[0] <= 2 && data[1] == 0 && data[2] == 0 && data[3] == 0 {
+		size := int(binary.BigEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) {
+			size = len(data)
+		}
+		logs = append(logs, data[:size]...)
+		data = data[size:]
+	}
+	return string(append(logs, data...))
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeEngine serves the part of the Engine API used by EngineBackend and
+// records the spec posted by the last service update.
+type fakeEngine struct {
+	spec    map[string]interface{}
+	updated map[string]interface{}
+	version string
+}
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/" + EngineAPIVersion + "/services":
+		w.Write([]byte(`[
+			{"ID": "s1", "Spec": {"Name": "web", "TaskTemplate": {"ContainerSpec": {"Image": "nginx:1.13"}}, "Mode": {"Replicated": {"Replicas": 2}}}},
+			{"ID": "s2", "Spec": {"Name": "worker", "Mode": {"Replicated": {"Replicas": 0}}}}
+		]`))
+	case "/" + EngineAPIVersion + "/tasks":
+		w.Write([]byte(`[
+			{"ID": "t1", "ServiceID": "s1", "NodeID": "n1", "Slot": 1, "Status": {"State": "running"}, "Spec": {"ContainerSpec": {"Image": "nginx:1.13@sha256:abc"}}},
+			{"ID": "t2", "ServiceID": "s1", "NodeID": "n1", "Slot": 2, "Status": {"State": "preparing"}}
+		]`))
+	case "/" + EngineAPIVersion + "/nodes":
+		w.Write([]byte(`[{"ID": "n1", "Description": {"Hostname": "node-1"}}]`))
+	case "/" + EngineAPIVersion + "/services/web":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ID":      "s1",
+			"Version": map[string]interface{}{"Index": 7},
+			"Spec":    f.spec,
+		})
+	case "/" + EngineAPIVersion + "/services/s1/update":
+		f.version = r.URL.Query().Get("version")
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &f.updated)
+		w.Write([]byte(`{}`))
+	case "/" + EngineAPIVersion + "/services/s1/logs":
+		w.Write([]byte{1, 0, 0, 0, 0, 0, 0, 6})
+		w.Write([]byte("hello\n"))
+		w.Write([]byte{2, 0, 0, 0, 0, 0, 0, 6})
+		w.Write([]byte("world\n"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "no such service"}`))
+	}
+}
+
+func newFakeEngine() (*fakeEngine, *EngineBackend, func()) {
+	engine := &fakeEngine{spec: map[string]interface{}{
+		"Name":         "web",
+		"Mode":         map[string]interface{}{"Replicated": map[string]interface{}{"Replicas": 2}},
+		"TaskTemplate": map[string]interface{}{"ContainerSpec": map[string]interface{}{"Image": "nginx:1.13"}},
+		"Networks":     []interface{}{map[string]interface{}{"Target": "overlay"}},
+	}}
+	server := httptest.NewServer(engine)
+	backend, err := NewEngineBackend("tcp://"+server.Listener.Addr().String(), nil)
+	if err != nil {
+		panic(err)
+	}
+	return engine, backend, server.Close
+}
+
+func TestEngineBackendServices(t *testing.T) {
+	_, backend, closeEngine := newFakeEngine()
+	defer closeEngine()
+
+	Convey("Subject: List and inspect services of the Engine\n", t, func() {
+		services, err := backend.ListServices()
+		So(err, ShouldBeNil)
+		So(len(services), ShouldEqual, 2)
+		So(services[0].Name, ShouldEqual, "web")
+		So(services[0].Running, ShouldEqual, 1)
+		So(services[0].Target, ShouldEqual, 2)
+		So(services[0].State, ShouldEqual, "Partly running")
+		So(services[1].State, ShouldEqual, "Stopped")
+
+		Convey("Containers are the tasks of the service", func() {
+			containers, err := backend.ListContainers("web")
+			So(err, ShouldBeNil)
+			So(len(containers), ShouldEqual, 2)
+			So(containers[0].Name, ShouldEqual, "web.1")
+			So(containers[0].Node, ShouldEqual, "node-1")
+			So(containers[0].ImageTag, ShouldEqual, "1.13")
+		})
+
+		Convey("Unknown services are not found", func() {
+			_, err := backend.GetService("unknown")
+			So(err, ShouldEqual, ErrNotFound)
+		})
+	})
+}
+
+func TestEngineBackendActions(t *testing.T) {
+	engine, backend, closeEngine := newFakeEngine()
+	defer closeEngine()
+
+	Convey("Subject: Update services of the Engine\n", t, func() {
+		Convey("Scale keeps the rest of the spec", func() {
+			So(backend.ScaleService("web", 5), ShouldBeNil)
+			So(engine.version, ShouldEqual, "7")
+			So(engine.updated["Mode"], ShouldResemble, map[string]interface{}{"Replicated": map[string]interface{}{"Replicas": float64(5)}})
+			So(engine.updated["Networks"], ShouldNotBeNil)
+		})
+
+		Convey("Stop saves the replicas to restore on start", func() {
+			_, err := backend.ServiceAction("web", "stop")
+			So(err, ShouldBeNil)
+			So(engine.updated["Labels"], ShouldResemble, map[string]interface{}{engineReplicasLabel: "2"})
+			So(engine.updated["Mode"], ShouldResemble, map[string]interface{}{"Replicated": map[string]interface{}{"Replicas": float64(0)}})
+		})
+
+		Convey("Restart forces the update of the tasks", func() {
+			_, err := backend.ServiceAction("web", "restart")
+			So(err, ShouldBeNil)
+			So(engine.updated["TaskTemplate"].(map[string]interface{})["ForceUpdate"], ShouldEqual, 1)
+		})
+
+		Convey("Logs are demultiplexed", func() {
+			logs, err := backend.ServiceLogs("web", 10)
+			So(err, ShouldBeNil)
+			So(logs, ShouldEqual, "hello\nworld\n")
+		})
+	})
+}
+
+func TestEngineBackendOfEnvironment(t *testing.T) {
+	Convey("Subject: Engine backends use the settings of their environment\n", t, func() {
+		env := &Environment{Name: "enginetest", ServiceBackend: ServiceBackendEngine, EngineAddress: "tcp://10.0.0.2:2376"}
+		first, err := NewServiceBackend(env)
+		So(err, ShouldBeNil)
+		second, err := NewServiceBackend(env)
+		So(err, ShouldBeNil)
+		So(second, ShouldEqual, first)
+
+		env.EngineTLSCA = "testdata/no-such-ca.pem"
+		_, err = NewServiceBackend(env)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestEngineBackendBreaker(t *testing.T) {
+	Convey("Subject: Engine server errors open the circuit\n", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"message": "swarm is down"}`))
+		}))
+		defer server.Close()
+		backend, err := NewEngineBackend("tcp://"+server.Listener.Addr().String(), nil)
+		So(err, ShouldBeNil)
+
+		_, err = backend.ListServices()
+		So(err, ShouldHaveSameTypeAs, &APIStatusError{})
+		So(err.(*APIStatusError).Body, ShouldEqual, "swarm is down")
+		for i := 1; i < breakerThreshold; i++ {
+			backend.ListServices()
+		}
+		_, err = backend.ListServices()
+		So(err, ShouldEqual, ErrCircuitOpen)
+	})
+}
@@ -0,0 +1,249 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+const (
+	// Env Tool
+	EnvToolName = "env"
+	EnvHelpMsg  = `env selects the environment the ops tools run against.
+
+Usage:
+	env
+	env list
+	env use NAME
+
+A single command can also target another environment:
+	dc -e NAME service web status
+	k8s -e NAME deploy`
+
+	// DefaultEnvName is the environment used when app.conf defines none
+	DefaultEnvName = "default"
+)
+
+// api clients by environment name, with the settings they were built from
+var envClients = struct {
+	sync.Mutex
+	m map[string]cachedAPIClient
+}{m: make(map[string]cachedAPIClient)}
+
+type cachedAPIClient struct {
+	settings string
+	client   *APIClient
+}
+
+// Environment is a named target of the ops tools. Environments are listed by
+// environments in app.conf, each one configured in its own [env-NAME] section:
+//
+//	environments = prod;staging
+//	defaultenv = prod
+//
+//	[env-staging]
+//	apiaddress = https://staging.xzdbd.com/
+//	apiuser = ...
+//	apipassword = ...
+//	apica = conf/staging-ca.pem
+//	servicebackend = engine
+//	engineaddress = tcp://10.0.0.2:2376
+//	enginetlsca = conf/staging-engine-ca.pem
+//	enginetlscert = conf/staging-engine-cert.pem
+//	enginetlskey = conf/staging-engine-key.pem
+//	kubeconfig = conf/staging.kubeconfig
+//
+// Missing keys fall back to the global settings.
+type Environment struct {
+	Name           string
+	APIAddress     string
+	APIUser        string
+	APIPassword    string
+	ServiceBackend string
+	EngineAddress  string
+	EngineTLSCA    string
+	EngineTLSCert  string
+	EngineTLSKey   string
+	Kubeconfig     string
+	K8sNamespace   string
+	APICA          string
+	APIInsecure    bool
+	APITimeout     time.Duration
+	APIRetries     int
+	Client         *APIClient
+
+	// ctx is the context of the request using the environment
+	ctx context.Context
+}
+
+type EnvTool struct {
+	toolBase
+	UserID  string
+	Action  string
+	EnvName string
+	HelpMsg string
+}
+
+// EnvironmentNames returns the configured environments.
+func EnvironmentNames() []string {
+	names := beego.AppConfig.Strings("environments")
+	if len(names) == 0 || names[0] == "" {
+		return []string{DefaultEnvName}
+	}
+	return names
+}
+
+// GetEnvironment returns the environment named name.
+func GetEnvironment(name string) (*Environment, error) {
+	found := false
+	for _, n := range EnvironmentNames() {
+		if n == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("未知环境%s，可用环境：%s", name, strings.Join(EnvironmentNames(), ", "))
+	}
+
+	section := "env-" + name + "::"
+	setting := func(key string, defaultval string) string {
+		return beego.AppConfig.DefaultString(section+key, beego.AppConfig.DefaultString(key, defaultval))
+	}
+	settingInt := func(key string, defaultval int) int {
+		n, err := strconv.Atoi(setting(key, ""))
+		if err != nil || n < 0 {
+			return defaultval
+		}
+		return n
+	}
+	env := &Environment{
+		Name:           name,
+		APIAddress:     setting("apiaddress", APIADDRESS),
+		APIUser:        setting("apiuser", ""),
+		APIPassword:    setting("apipassword", ""),
+		ServiceBackend: setting("servicebackend", ServiceBackendDockerCloud),
+		EngineAddress:  setting("engineaddress", "unix:///var/run/docker.sock"),
+		EngineTLSCA:    setting("enginetlsca", ""),
+		EngineTLSCert:  setting("enginetlscert", ""),
+		EngineTLSKey:   setting("enginetlskey", ""),
+		Kubeconfig:     setting("kubeconfig", ""),
+		K8sNamespace:   setting("k8snamespace", "default"),
+		APICA:          setting("apica", ""),
+		APIInsecure:    setting("apiinsecure", "false") == "true",
+		APITimeout:     time.Duration(settingInt("apitimeout", int(DefaultAPITimeout/time.Second))) * time.Second,
+		APIRetries:     settingInt("apiretries", DefaultAPIRetries),
+	}
+	var err error
+	if env.Client, err = getAPIClient(env); err != nil {
+		return nil, fmt.Errorf("环境%s的API配置有误：%s", name, err)
+	}
+	return env, nil
+}
+
+// getAPIClient builds the api client of env once, again only when its api
+// settings change, so the tools share its connections.
+func getAPIClient(env *Environment) (*APIClient, error) {
+	settings := fmt.Sprintf("%s|%s|%s|%s|%t|%s|%d", env.APIAddress, env.APIUser, env.APIPassword, env.APICA,
+		env.APIInsecure, env.APITimeout, env.APIRetries)
+	envClients.Lock()
+	defer envClients.Unlock()
+
+	if cached, ok := envClients.m[env.Name]; ok && cached.settings == settings {
+		return cached.client, nil
+	}
+	client, err := NewAPIClient(env)
+	if err != nil {
+		return nil, err
+	}
+	envClients.m[env.Name] = cachedAPIClient{settings: settings, client: client}
+	return client, nil
+}
+
+// WithContext returns a copy of the environment whose backend calls stop
+// when ctx is done.
+func (e *Environment) WithContext(ctx context.Context) *Environment {
+	env := *e
+	env.ctx = ctx
+	if e.Client != nil {
+		env.Client = e.Client.WithContext(ctx)
+	}
+	return &env
+}
+
+// Context is the context of the request, background if none is set.
+func (e *Environment) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// ReplyPrefix shows the environment a reply comes from.
+func (e *Environment) ReplyPrefix() string {
+	return fmt.Sprintf("[%s] ", e.Name)
+}
+
+// DefaultEnvironment returns the environment set by defaultenv in app.conf.
+func DefaultEnvironment() (*Environment, error) {
+	return GetEnvironment(beego.AppConfig.DefaultString("defaultenv", EnvironmentNames()[0]))
+}
+
+// UserEnvironment returns the environment a command of the user runs
+// against: the one named in the command, else the default of the user, else
+// defaultenv of app.conf.
+func UserEnvironment(userID string, name string) (*Environment, error) {
+	if name != "" {
+		return GetEnvironment(name)
+	}
+	if name = GetUserConfig(userID, "env"); name != "" {
+		if env, err := GetEnvironment(name); err == nil {
+			return env, nil
+		}
+		beego.Warn("User default environment is removed from app.conf. User:", userID, "Env:", name)
+	}
+	return DefaultEnvironment()
+}
+
+func (e *EnvTool) NewTool(req Request) {
+	e.name = EnvToolName
+	e.HelpMsg = EnvHelpMsg
+	e.UserID = req.FromUserName
+}
+
+func (e *EnvTool) Run() (TextResponse, error) {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+
+	switch e.Action {
+	case "show", "list":
+		current, err := UserEnvironment(e.UserID, "")
+		if err != nil {
+			return textResp, err
+		}
+		textResp.Content = fmt.Sprintf("当前环境：%s\n可用环境：\n", current.Name)
+		for i, name := range EnvironmentNames() {
+			textResp.Content += fmt.Sprintf("%d. %s\n", i+1, name)
+		}
+	case "use":
+		if _, err := GetEnvironment(e.EnvName); err != nil {
+			textResp.Content = err.Error()
+			break
+		}
+		if err := SetUserConfig(e.UserID, "env", e.EnvName); err != nil {
+			textResp.Content = fmt.Sprintf("设置默认环境失败。")
+			break
+		}
+		beego.Info("Set user default environment:", e.UserID, e.EnvName)
+		textResp.Content = fmt.Sprintf("默认环境已设置为%s。", e.EnvName)
+	default:
+		return textResp, errors.New("Invalid Action. Valid actions are 'list' and 'use'")
+	}
+	return textResp, nil
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+// ProcessingMsg is the passive reply sent when a tool misses the deadline.
+const ProcessingMsg = "正在处理中，结果稍后推送给您。"
+
+var (
+	// ReplyDeadline is how long a tool may run before the passive reply is
+	// sent, kept below the 5 seconds WeChat waits before retrying the message.
+	ReplyDeadline = time.Duration(beego.AppConfig.DefaultInt("replydeadline", 4000)) * time.Millisecond
+
+	// RequestTimeout is how long the tools of a request may run, pushed late
+	// replies included. Their backend calls are cancelled after it.
+	RequestTimeout = time.Duration(beego.AppConfig.DefaultInt("requesttimeout", 60)) * time.Second
+)
+
+// RunBeforeDeadline runs handle and returns its reply if it finishes before
+// ctx is done. Otherwise it returns false and the reply is pushed to the user
+// through the customer service message API once handle finishes. handle is
+// given a context cancelled after RequestTimeout, or as soon as it returns.
+func RunBeforeDeadline(ctx context.Context, openID string, handle func(context.Context) interface{}) (interface{}, bool) {
+	done := make(chan interface{}, 1)
+	go func() {
+		work, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+		defer cancel()
+		done <- handle(work)
+	}()
+
+	select {
+	case reply := <-done:
+		return reply, true
+	case <-ctx.Done():
+		beego.Info("Reply deadline exceeded, the result will be pushed. User:", openID)
+		go func() {
+			PushLater(openID, <-done)
+		}()
+		return nil, false
+	}
+}
+
+// FitsReply reports whether the reply can be the passive reply. A text
+// longer than one message is to be pushed with PushLater instead, as a
+// passive first part may arrive after the pushed rest.
+func FitsReply(reply interface{}) bool {
+	text, ok := reply.(TextResponse)
+	return !ok || len(text.Content) <= WeChatTextLimit
+}
+
+// PushLater pushes the reply, all of its parts in order, logging a failure.
+func PushLater(openID string, reply interface{}) {
+	if err := PushReply(openID, reply); err != nil {
+		beego.Error("Failed to push delayed reply. User:", openID, "Error:", err.Error())
+	}
+}
+
+// PushReply pushes a passive reply, a TextResponse or a NewsResponse, to the
+// user.
+func PushReply(openID string, reply interface{}) error {
+	switch r := reply.(type) {
+	case TextResponse:
+		parts := SplitText(r.Content, WeChatTextLimit)
+		for _, part := range parts {
+			if err := PushText(openID, part); err != nil {
+				return err
+			}
+		}
+	case NewsResponse:
+		return PushNews(openID, r.Articles)
+	}
+	return nil
+}
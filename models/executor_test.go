@@ -0,0 +1,53 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRunBeforeDeadline(t *testing.T) {
+	Convey("Subject: Reply before the WeChat deadline\n", t, func() {
+		Convey("Replies made in time are returned", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			reply, ok := RunBeforeDeadline(ctx, "user", func(context.Context) interface{} {
+				return TextResponse{Content: "done"}
+			})
+			So(ok, ShouldBeTrue)
+			So(reply.(TextResponse).Content, ShouldEqual, "done")
+		})
+
+		Convey("Slow tools miss the deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, ok := RunBeforeDeadline(ctx, "user", func(context.Context) interface{} {
+				time.Sleep(100 * time.Millisecond)
+				return nil
+			})
+			So(ok, ShouldBeFalse)
+			So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+		})
+
+		Convey("The work of a reply made in time is cancelled", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			var work context.Context
+			RunBeforeDeadline(ctx, "user", func(ctx context.Context) interface{} {
+				work = ctx
+				return nil
+			})
+			So(work.Err(), ShouldEqual, context.Canceled)
+		})
+	})
+
+	Convey("Subject: Push replies too long for a message\n", t, func() {
+		So(FitsReply(TextResponse{Content: "short"}), ShouldBeTrue)
+		So(FitsReply(NewsResponse{}), ShouldBeTrue)
+		So(FitsReply(TextResponse{Content: strings.Repeat("日志行\n", WeChatTextLimit/5)}), ShouldBeFalse)
+	})
+}
@@ -1,10 +1,9 @@
 package models
 
 import (
-	"crypto/tls"
+	"context"
+	"net/url"
 	"strconv"
-
-	"github.com/astaxie/beego/httplib"
 )
 
 const (
@@ -15,29 +14,44 @@ const (
 	GoogleHelpMsg      = `google is a google search tool. Enjoy!
 
 Usage:
-	google KEY 
+	google [--n N] [--site SITE] [--lang LANG] KEY
+	google next
 or
-	g KEY 
+	g KEY
+	g next
 
 KEY:
 	search key words.
 
+Options:
+	--n      number of results, 1 to 8, 4 by default
+	--site   only search the site, e.g. github.com
+	--lang   language of the results, e.g. en, zh-CN
+
+next:
+	the next page of your last search.
+
 Example:
-	google happy day	
+	google happy day
+	g --n 8 --site github.com beego
 	`
+
+	GoogleProviderName = "google"
+
+	// GoogleLogo is the thumbnail of results without a favicon
+	GoogleLogo = "https://upload.wikimedia.org/wikipedia/commons/thumb/5/53/Google_%22G%22_Logo.svg/200px-Google_%22G%22_Logo.svg.png"
 )
 
+// GoogleTool is the search tool bound to the google provider.
 type GoogleTool struct {
-	toolBase
-	Key     string
-	N       int
-	HelpMsg string
+	SearchTool
 }
 
 type GoogleResult struct {
 	Abstract  string
 	Title     string
 	URL       string
+	Image     string
 	Sitelinks []Sitelinks
 }
 
@@ -48,42 +62,54 @@ type Sitelinks struct {
 	URL      string
 }
 
+// googleProvider searches through the google proxy of the ops api.
+type googleProvider struct{}
+
+func init() {
+	RegisterSearchProvider(GoogleProviderName, googleProvider{})
+}
+
 func (g *GoogleTool) NewTool() {
+	g.SearchTool.NewTool()
 	g.name = GoogleToolName
 	g.alias = GoogleToolAlias
 	g.endpoint = GoogleToolEndpoint
 	g.HelpMsg = GoogleHelpMsg
+	g.Provider = GoogleProviderName
 }
 
-func (g *GoogleTool) Run() (NewsResponse, error) {
+func (googleProvider) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
 	var GoogleResultList []*GoogleResult
-	GoogleResultList = make([]*GoogleResult, g.N)
-	var newsResp NewsResponse
-	newsResp.MsgType = MsgTypeNews
 
-	req := httplib.Get(APIADDRESS + APIVERSION + g.endpoint)
-	req.Param("key", g.Key)
-	req.Param("n", strconv.Itoa(g.N))
-	req.SetBasicAuth(apiuser, apipassword)
-	req.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-
-	err := req.ToJSON(&GoogleResultList)
+	env, err := DefaultEnvironment()
 	if err != nil {
-		return newsResp, err
+		return nil, err
 	}
-
-	newsResp.ArticleCount = len(GoogleResultList)
-	for i := 0; i < newsResp.ArticleCount; i++ {
-		//picUrl := getFavicons(GoogleResultList[i].URL)
-		picUrl := "https://upload.wikimedia.org/wikipedia/commons/thumb/5/53/Google_%22G%22_Logo.svg/200px-Google_%22G%22_Logo.svg.png"
-		item := Item{Title: GoogleResultList[i].Title, Description: GoogleResultList[i].Abstract, Url: GoogleResultList[i].URL, PicUrl: picUrl}
-		newsResp.Articles = append(newsResp.Articles, &item)
+	params := url.Values{"key": {query.Key}, "n": {strconv.Itoa(query.N)}}
+	if query.Start > 0 {
+		params.Set("start", strconv.Itoa(query.Start))
+	}
+	if query.Site != "" {
+		params.Set("site", query.Site)
+	}
+	if query.Lang != "" {
+		params.Set("lang", query.Lang)
+	}
+	err = env.Client.WithContext(ctx).Get(GoogleToolEndpoint, params, &GoogleResultList)
+	if err != nil {
+		return nil, err
 	}
 
-	return newsResp, nil
+	results := make([]SearchResult, 0, len(GoogleResultList))
+	for _, result := range GoogleResultList {
+		results = append(results, SearchResult{Title: result.Title, Abstract: result.Abstract,
+			URL: result.URL, Image: result.Image})
+	}
+	return results, nil
 }
 
-func getFavicons(domain string) string {
-	//return "https://www.google.com/s2/favicons?domain=" + domain
-	return "https://api.byi.pw/favicon/?url=" + domain
+func (googleProvider) Logo() string {
+	return GoogleLogo
 }
+
+func (googleProvider) searchesWeb() {}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/astaxie/beego"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newFakeGoogle serves n results numbered from start and records the query
+// of the last search.
+func newFakeGoogle() (*url.Values, func()) {
+	var last url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r.URL.Query()
+		n, _ := strconv.Atoi(last.Get("n"))
+		start, _ := strconv.Atoi(last.Get("start"))
+		w.Write([]byte("["))
+		for i := 0; i < n+2; i++ {
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+			fmt.Fprintf(w, `{"Title": "result %d", "URL": "https://example.com/%d", "Image": "https://example.com/%d.png"}`, start+i, start+i, start+i)
+		}
+		w.Write([]byte("]"))
+	}))
+	old := beego.AppConfig.String("apiaddress")
+	beego.AppConfig.Set("apiaddress", server.URL+"/")
+	return &last, func() {
+		beego.AppConfig.Set("apiaddress", old)
+		server.Close()
+	}
+}
+
+func TestGoogleToolPaging(t *testing.T) {
+	Convey("Subject: Google search options and paging\n", t, func() {
+		last, done := newFakeGoogle()
+		defer done()
+
+		var g GoogleTool
+		g.NewTool()
+		g.UserID = "user"
+		g.Key = "beego"
+		g.N = 20
+		g.Site = "github.com"
+		g.Lang = "en"
+
+		Convey("Results are capped at the news limit", func() {
+			resp, err := g.Run()
+			So(err, ShouldBeNil)
+			So(resp.ArticleCount, ShouldEqual, SearchMaxN)
+			So(last.Get("site"), ShouldEqual, "github.com")
+			So(last.Get("lang"), ShouldEqual, "en")
+		})
+
+		Convey("Next continues the last search of the user", func() {
+			g.N = 3
+			_, err := g.Run()
+			So(err, ShouldBeNil)
+
+			var next GoogleTool
+			next.NewTool()
+			next.UserID = "user"
+			next.Next = true
+			resp, err := next.Run()
+			So(err, ShouldBeNil)
+			So(last.Get("key"), ShouldEqual, "beego")
+			So(last.Get("start"), ShouldEqual, "3")
+			So(last.Get("site"), ShouldEqual, "github.com")
+			So(resp.Articles[0].Title, ShouldEqual, "result 3")
+		})
+
+		Convey("Next without a search is reported", func() {
+			var next GoogleTool
+			next.NewTool()
+			next.UserID = "someone else"
+			next.Next = true
+			_, err := next.Run()
+			So(err, ShouldEqual, ErrSearchNoQuery)
+		})
+	})
+}
+
+func TestGoogleThumbnails(t *testing.T) {
+	Convey("Subject: Per-result thumbnails\n", t, func() {
+		withIcon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/favicon.ico" {
+				w.Header().Set("Content-Type", "image/x-icon")
+				w.Write([]byte{0, 0, 1, 0})
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer withIcon.Close()
+		withoutIcon := httptest.NewServer(http.NotFoundHandler())
+		defer withoutIcon.Close()
+
+		thumbnails := searchThumbnails([]SearchResult{
+			{URL: withIcon.URL + "/page"},
+			{URL: withoutIcon.URL + "/page"},
+			{URL: withoutIcon.URL + "/other", Image: "https://example.com/image.png"},
+		}, googleProvider{})
+		So(thumbnails[0], ShouldEqual, withIcon.URL+"/favicon.ico")
+		So(thumbnails[1], ShouldEqual, GoogleLogo)
+		So(thumbnails[2], ShouldEqual, "https://example.com/image.png")
+
+		Convey("Favicons are cached by host", func() {
+			withIcon.Close()
+			So(getFavicon(withIcon.URL+"/another"), ShouldEqual, withIcon.URL+"/favicon.ico")
+		})
+
+		Convey("Internal providers get no favicons", func() {
+			internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("fetched %s from an internal site", r.URL.Path)
+			}))
+			defer internal.Close()
+			thumbnails := searchThumbnails([]SearchResult{{URL: internal.URL + "/page"}}, wikiProvider{})
+			So(thumbnails[0], ShouldEqual, wikiProvider{}.Logo())
+		})
+	})
+}
@@ -0,0 +1,297 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// Kubernetes Tool
+	K8sToolName  = "kubernetes"
+	K8sToolAlias = "k8s"
+	K8sHelpMsg   = `kubernetes is an operations tool for deployments.
+
+Usage:
+	k8s [--ns NAMESPACE] deploy
+	k8s [--ns NAMESPACE] pods [DEPLOYMENT]
+	k8s [--ns NAMESPACE] status DEPLOYMENT
+	k8s [--ns NAMESPACE] restart DEPLOYMENT
+	k8s [--ns NAMESPACE] scale DEPLOYMENT N
+	k8s [--ns NAMESPACE] logs POD [LINES]
+
+Example
+	k8s --ns prod deploy
+	k8s status web
+	k8s scale web 3`
+
+	k8sRestartAnnotation = "kubectl.kubernetes.io/restartedAt"
+	// k8sRequestTimeout is the timeout of the http client of the api server,
+	// its expiry counts as a failure of the cluster
+	k8sRequestTimeout = 10 * time.Second
+)
+
+var (
+	// clients by environment name, with the kubeconfig they were built from
+	k8sClients    = make(map[string]cachedK8sClient)
+	k8sClientLock sync.Mutex
+)
+
+type cachedK8sClient struct {
+	kubeconfig string
+	client     kubernetes.Interface
+}
+
+type K8sTool struct {
+	toolBase
+	Env       *Environment
+	Namespace string
+	Name      string
+	Action    string
+	Replicas  int32
+	Lines     int64
+	HelpMsg   string
+	// Client defaults to the client built from the kubeconfig of Env or the
+	// in-cluster credentials
+	Client kubernetes.Interface
+}
+
+// NewTool targets the namespace configured for env, which may be changed
+// afterwards.
+func (k *K8sTool) NewTool(env *Environment) {
+	k.name = K8sToolName
+	k.alias = K8sToolAlias
+	k.HelpMsg = K8sHelpMsg
+	k.Env = env
+	k.Namespace = env.K8sNamespace
+}
+
+func (k *K8sTool) Run() (TextResponse, error) {
+	var textResp TextResponse
+	var err error
+	textResp.MsgType = MsgTypeText
+
+	// invalid actions make no request, so they don't reach the breaker
+	switch k.Action {
+	case "deploy", "pods", "status", "restart", "scale", "logs":
+	default:
+		return textResp, errors.New("Invalid Action. Valid actions are 'deploy', 'pods', 'status', 'restart', 'scale' and 'logs'")
+	}
+
+	if k.Client == nil {
+		k.Client, err = getK8sClient(k.Env)
+		if err != nil {
+			textResp.Content = fmt.Sprintf("连接Kubernetes失败，错误信息：%s", err.Error())
+			return textResp, nil
+		}
+	}
+
+	breaker := GetCircuitBreaker("kubernetes " + k.Env.Name)
+	if err := breaker.Allow(); err != nil {
+		textResp.Content = BackendErrorMessage(err)
+		return textResp, nil
+	}
+	ctx := k.Context()
+
+	switch k.Action {
+	case "deploy":
+		textResp.Content, err = k.listDeployments(ctx)
+	case "pods":
+		textResp.Content, err = k.listPods(ctx)
+	case "status":
+		textResp.Content, err = k.rolloutStatus(ctx)
+	case "restart":
+		err = k.rolloutRestart(ctx)
+		textResp.Content = fmt.Sprintf("部署%s已重启，使用k8s status %s查看进度。", k.Name, k.Name)
+	case "scale":
+		err = k.scale(ctx)
+		textResp.Content = fmt.Sprintf("部署%s已调整为%d个副本，使用k8s status %s查看进度。", k.Name, k.Replicas, k.Name)
+	case "logs":
+		textResp.Content, err = k.logs(ctx)
+	}
+	breaker.RecordContext(ctx, err)
+	if err != nil {
+		textResp.Content = k8sErrorMessage(err)
+	}
+	return textResp, nil
+}
+
+func (k *K8sTool) listDeployments(ctx context.Context) (string, error) {
+	deployments, err := k.Client.AppsV1().Deployments(k.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	content := fmt.Sprintf("命名空间%s共有%d个部署。\n", k.Namespace, len(deployments.Items))
+	for i, d := range deployments.Items {
+		content += fmt.Sprintf("%d. %s: %d/%d\n", i+1, d.Name, d.Status.ReadyReplicas, deploymentReplicas(d))
+	}
+	return content, nil
+}
+
+// listPods lists the pods of the deployment, or of the namespace if no
+// deployment is given.
+func (k *K8sTool) listPods(ctx context.Context) (string, error) {
+	opts := metav1.ListOptions{}
+	if k.Name != "" {
+		d, err := k.Client.AppsV1().Deployments(k.Namespace).Get(ctx, k.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+		if err != nil {
+			return "", err
+		}
+		opts.LabelSelector = selector.String()
+	}
+	pods, err := k.Client.CoreV1().Pods(k.Namespace).List(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	content := fmt.Sprintf("共有%d个Pod。\n", len(pods.Items))
+	for i, pod := range pods.Items {
+		content += fmt.Sprintf("%d. %s: %s\n", i+1, pod.Name, pod.Status.Phase)
+		content += fmt.Sprintf("    节点：%s\n", pod.Spec.NodeName)
+		content += fmt.Sprintf("    重启次数：%d\n", podRestarts(pod))
+	}
+	return content, nil
+}
+
+// rolloutStatus follows the checks of kubectl rollout status.
+func (k *K8sTool) rolloutStatus(ctx context.Context) (string, error) {
+	d, err := k.Client.AppsV1().Deployments(k.Namespace).Get(ctx, k.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	replicas := deploymentReplicas(*d)
+	content := fmt.Sprintf("部署：%s\n副本：%d就绪，%d可用，%d已更新，目标%d个\n", d.Name,
+		d.Status.ReadyReplicas, d.Status.AvailableReplicas, d.Status.UpdatedReplicas, replicas)
+
+	if d.Generation > d.Status.ObservedGeneration {
+		return content + "等待新的部署配置生效。", nil
+	}
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			return content + fmt.Sprintf("部署超时失败：%s", cond.Message), nil
+		}
+	}
+	switch {
+	case d.Status.UpdatedReplicas < replicas:
+		content += fmt.Sprintf("部署中，%d/%d个新副本已更新。", d.Status.UpdatedReplicas, replicas)
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		content += fmt.Sprintf("部署中，%d个旧副本等待终止。", d.Status.Replicas-d.Status.UpdatedReplicas)
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		content += fmt.Sprintf("部署中，%d/%d个新副本可用。", d.Status.AvailableReplicas, d.Status.UpdatedReplicas)
+	default:
+		content += "部署完成。"
+	}
+	return content, nil
+}
+
+// rolloutRestart patches the pod template the same way as kubectl rollout restart.
+func (k *K8sTool) rolloutRestart(ctx context.Context) error {
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`,
+		k8sRestartAnnotation, time.Now().Format(time.RFC3339))
+	_, err := k.Client.AppsV1().Deployments(k.Namespace).Patch(ctx, k.Name, types.StrategicMergePatchType,
+		[]byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+func (k *K8sTool) scale(ctx context.Context) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		d, err := k.Client.AppsV1().Deployments(k.Namespace).Get(ctx, k.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		d.Spec.Replicas = &k.Replicas
+		_, err = k.Client.AppsV1().Deployments(k.Namespace).Update(ctx, d, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (k *K8sTool) logs(ctx context.Context) (string, error) {
+	logs, err := k.Client.CoreV1().Pods(k.Namespace).GetLogs(k.Name, &corev1.PodLogOptions{TailLines: &k.Lines}).DoRaw(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(logs) == 0 {
+		return fmt.Sprintf("Pod %s没有日志。", k.Name), nil
+	}
+	// keep the latest lines if the logs are too long for one message, leaving
+	// room for the environment prefix of the reply
+	limit := WeChatTextLimit
+	if k.Env != nil {
+		limit -= len(k.Env.ReplyPrefix())
+	}
+	parts := SplitText(string(logs), limit)
+	return parts[len(parts)-1], nil
+}
+
+// getK8sClient builds the client of env once, again only when its kubeconfig
+// changes. The in-cluster service account is used when it is not set.
+func getK8sClient(env *Environment) (kubernetes.Interface, error) {
+	k8sClientLock.Lock()
+	defer k8sClientLock.Unlock()
+
+	if cached, ok := k8sClients[env.Name]; ok && cached.kubeconfig == env.Kubeconfig {
+		return cached.client, nil
+	}
+	var config *rest.Config
+	var err error
+	if env.Kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", env.Kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = k8sRequestTimeout
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	k8sClients[env.Name] = cachedK8sClient{kubeconfig: env.Kubeconfig, client: client}
+	return client, nil
+}
+
+// k8sErrorMessage explains the errors of the API server, the access is
+// decided by the RBAC rules bound to the credentials of the bot.
+func k8sErrorMessage(err error) string {
+	switch {
+	case apierrors.IsForbidden(err):
+		return fmt.Sprintf("没有权限执行该操作，请检查RBAC授权：%s", err.Error())
+	case apierrors.IsNotFound(err):
+		return fmt.Sprintf("没有找到该资源：%s", err.Error())
+	case apierrors.IsUnauthorized(err):
+		return "Kubernetes认证失败，请检查凭据。"
+	default:
+		return fmt.Sprintf("Kubernetes错误，错误信息：%s", err.Error())
+	}
+}
+
+func deploymentReplicas(d appsv1.Deployment) int32 {
+	if d.Spec.Replicas == nil {
+		return 1
+	}
+	return *d.Spec.Replicas
+}
+
+func podRestarts(pod corev1.Pod) int32 {
+	var restarts int32
+	for _, status := range pod.Status.ContainerStatuses {
+		restarts += status.RestartCount
+	}
+	return restarts
+}
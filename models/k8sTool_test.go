@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newK8sTestTool(action string, name string) *K8sTool {
+	replicas := int32(2)
+	labels := map[string]string{"app": "web"}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "prod", Generation: 1},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 1,
+			Replicas:           2,
+			UpdatedReplicas:    2,
+			ReadyReplicas:      1,
+			AvailableReplicas:  1,
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "prod", Labels: labels},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{RestartCount: 3}},
+		},
+	}
+	other := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-1", Namespace: "prod"}}
+
+	var k K8sTool
+	k.NewTool(&Environment{Name: "prod", K8sNamespace: "prod"})
+	k.Action = action
+	k.Name = name
+	k.Client = fake.NewSimpleClientset(deployment, pod, other)
+	return &k
+}
+
+func TestK8sToolRead(t *testing.T) {
+	Convey("Subject: Read deployments and pods\n", t, func() {
+		Convey("Deployments show ready and desired replicas", func() {
+			resp, err := newK8sTestTool("deploy", "").Run()
+			So(err, ShouldBeNil)
+			So(resp.Content, ShouldContainSubstring, "1. web: 1/2")
+		})
+
+		Convey("Pods are selected by the deployment", func() {
+			resp, err := newK8sTestTool("pods", "web").Run()
+			So(err, ShouldBeNil)
+			So(resp.Content, ShouldContainSubstring, "共有1个Pod")
+			So(resp.Content, ShouldContainSubstring, "重启次数：3")
+		})
+
+		Convey("Rollout status waits for available replicas", func() {
+			resp, err := newK8sTestTool("status", "web").Run()
+			So(err, ShouldBeNil)
+			So(resp.Content, ShouldContainSubstring, "1/2个新副本可用")
+		})
+
+		Convey("Unknown deployments are reported", func() {
+			resp, err := newK8sTestTool("status", "api").Run()
+			So(err, ShouldBeNil)
+			So(resp.Content, ShouldStartWith, "没有找到该资源")
+		})
+
+		Convey("Invalid actions return an error", func() {
+			_, err := newK8sTestTool("delete", "web").Run()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestK8sToolWrite(t *testing.T) {
+	Convey("Subject: Restart and scale deployments\n", t, func() {
+		Convey("Restart annotates the pod template", func() {
+			k := newK8sTestTool("restart", "web")
+			_, err := k.Run()
+			So(err, ShouldBeNil)
+			d, _ := k.Client.AppsV1().Deployments("prod").Get(context.TODO(), "web", metav1.GetOptions{})
+			So(d.Spec.Template.Annotations[k8sRestartAnnotation], ShouldNotBeEmpty)
+		})
+
+		Convey("Scale updates the replicas", func() {
+			k := newK8sTestTool("scale", "web")
+			k.Replicas = 5
+			_, err := k.Run()
+			So(err, ShouldBeNil)
+			d, _ := k.Client.AppsV1().Deployments("prod").Get(context.TODO(), "web", metav1.GetOptions{})
+			So(*d.Spec.Replicas, ShouldEqual, 5)
+		})
+
+		Convey("Requests denied by RBAC are explained", func() {
+			k := newK8sTestTool("scale", "web")
+			k.Client.(*fake.Clientset).PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "deployments"}, "web", errors.New("user cannot update"))
+			})
+			resp, err := k.Run()
+			So(err, ShouldBeNil)
+			So(resp.Content, ShouldStartWith, "没有权限执行该操作")
+		})
+
+		Convey("An unavailable API server opens the circuit", func() {
+			k := newK8sTestTool("deploy", "")
+			k.Env.Name = "k8sbreakertest"
+			k.Client.(*fake.Clientset).PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewServiceUnavailable("overloaded")
+			})
+			for i := 0; i < breakerThreshold; i++ {
+				k.Run()
+			}
+			resp, err := k.Run()
+			So(err, ShouldBeNil)
+			So(resp.Content, ShouldEqual, BackendErrorMessage(ErrCircuitOpen))
+		})
+
+		Convey("Invalid actions don't count in the breaker", func() {
+			k := newK8sTestTool("deploy", "")
+			k.Env.Name = "k8sinvalidtest"
+			k.Client.(*fake.Clientset).PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewServiceUnavailable("overloaded")
+			})
+			k.Run()
+			k.Action = "foo"
+			_, err := k.Run()
+			So(err, ShouldNotBeNil)
+			So(GetCircuitBreaker("kubernetes k8sinvalidtest").Status().Failures, ShouldEqual, 1)
+		})
+	})
+}
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://10.0.0.3:6443
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`
+
+func TestK8sClientOfEnvironment(t *testing.T) {
+	Convey("Subject: Kubernetes clients use the kubeconfig of their environment\n", t, func() {
+		dir, _ := ioutil.TempDir("", "kubeconfig")
+		defer os.RemoveAll(dir)
+		first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+		ioutil.WriteFile(first, []byte(testKubeconfig), 0600)
+		ioutil.WriteFile(second, []byte(testKubeconfig), 0600)
+
+		env := &Environment{Name: "k8sclienttest", Kubeconfig: first}
+		client, err := getK8sClient(env)
+		So(err, ShouldBeNil)
+		again, err := getK8sClient(env)
+		So(err, ShouldBeNil)
+		So(again == client, ShouldBeTrue)
+
+		env.Kubeconfig = second
+		changed, err := getK8sClient(env)
+		So(err, ShouldBeNil)
+		So(changed == client, ShouldBeFalse)
+
+		env.Kubeconfig = filepath.Join(dir, "missing")
+		_, err = getK8sClient(env)
+		So(err, ShouldNotBeNil)
+	})
+}
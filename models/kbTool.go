@@ -0,0 +1,331 @@
+package models
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/astaxie/beego"
+)
+
+const (
+	// Kb Tool
+	KbToolName = "kb"
+	KbHelpMsg  = `kb searches the runbooks of the ops team.
+
+Usage:
+	kb KEY
+
+KEY:
+	search key words.
+
+Example:
+	kb redis failover
+	`
+
+	// runes of a section shown as the abstract
+	kbAbstractLength = 100
+	// a heading counts as much as this many words of the section text
+	kbHeadingWeight = 3
+)
+
+var (
+	ErrKbNoResult = errors.New("没有找到相关的运维手册。")
+
+	kb = &kbIndex{}
+)
+
+type KbTool struct {
+	toolBase
+	Key     string
+	N       int
+	HelpMsg string
+}
+
+// kbSection is the part of a runbook under one heading.
+type kbSection struct {
+	File    string
+	Title   string
+	Heading string
+	Anchor  string
+	Text    string
+	terms   map[string]int
+}
+
+// kbIndex is a full-text index of the markdown runbooks in a directory. It is
+// rebuilt when a runbook is added, removed or modified.
+type kbIndex struct {
+	sync.Mutex
+	dir       string
+	signature string
+	sections  []*kbSection
+	postings  map[string][]int
+}
+
+type kbMatch struct {
+	section *kbSection
+	score   float64
+}
+
+func (k *KbTool) NewTool() {
+	k.name = KbToolName
+	k.HelpMsg = KbHelpMsg
+	k.N = SearchDefaultN
+}
+
+func (k *KbTool) Run() (NewsResponse, error) {
+	var newsResp NewsResponse
+	newsResp.MsgType = MsgTypeNews
+
+	matches, err := kb.search(beego.AppConfig.DefaultString("kbdir", "runbooks"), k.Key, k.N)
+	if err != nil {
+		return newsResp, err
+	}
+	if len(matches) == 0 {
+		return newsResp, ErrKbNoResult
+	}
+
+	baseURL := beego.AppConfig.String("kburl")
+	logo := beego.AppConfig.String("kblogo")
+	for _, match := range matches {
+		section := match.section
+		title := section.Title
+		if section.Heading != section.Title {
+			title += " - " + section.Heading
+		}
+		link := ""
+		if baseURL != "" {
+			link = strings.TrimSuffix(baseURL, "/") + "/" + section.File
+			if section.Anchor != "" {
+				link += "#" + section.Anchor
+			}
+		}
+		item := Item{Title: title, Description: kbAbstract(section.Text), Url: link, PicUrl: logo}
+		newsResp.Articles = append(newsResp.Articles, &item)
+	}
+	newsResp.ArticleCount = len(newsResp.Articles)
+	return newsResp, nil
+}
+
+// search returns the n sections matching most of the key words, best first.
+func (idx *kbIndex) search(dir string, key string, n int) ([]kbMatch, error) {
+	idx.Lock()
+	defer idx.Unlock()
+	if err := idx.refresh(dir); err != nil {
+		return nil, err
+	}
+
+	terms := kbTerms(key)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	scores := make(map[int]float64)
+	matched := make(map[int]int)
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(1 + float64(len(idx.sections))/float64(len(postings)))
+		for _, i := range postings {
+			tf := float64(idx.sections[i].terms[term])
+			scores[i] += (1 + math.Log(tf)) * idf
+			matched[i]++
+		}
+	}
+
+	matches := make([]kbMatch, 0, len(scores))
+	for i, score := range scores {
+		// sections with more of the key words always rank first
+		score += float64(matched[i]) * 100
+		matches = append(matches, kbMatch{section: idx.sections[i], score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].section.File+matches[i].section.Anchor < matches[j].section.File+matches[j].section.Anchor
+	})
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+	return matches, nil
+}
+
+// refresh rebuilds the index if the runbooks in dir changed since the last
+// build.
+func (idx *kbIndex) refresh(dir string) error {
+	var files []string
+	var signature strings.Builder
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.EqualFold(filepath.Ext(path), ".md") {
+			files = append(files, path)
+			fmt.Fprintf(&signature, "%s|%d|%d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("读取运维手册失败：%s", err)
+	}
+	if dir == idx.dir && signature.String() == idx.signature {
+		return nil
+	}
+
+	idx.sections = nil
+	idx.postings = make(map[string][]int)
+	for _, path := range files {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("读取运维手册失败：%s", err)
+		}
+		rel, _ := filepath.Rel(dir, path)
+		for _, section := range parseRunbook(filepath.ToSlash(rel), string(content)) {
+			i := len(idx.sections)
+			idx.sections = append(idx.sections, section)
+			for term := range section.terms {
+				idx.postings[term] = append(idx.postings[term], i)
+			}
+		}
+	}
+	idx.dir = dir
+	idx.signature = signature.String()
+	beego.Info("Runbook index rebuilt. Files:", len(files), "Sections:", len(idx.sections))
+	return nil
+}
+
+// parseRunbook splits a markdown runbook into its sections. The text before
+// the first heading belongs to a section named after the runbook.
+func parseRunbook(file string, content string) []*kbSection {
+	title := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	var sections []*kbSection
+	current := &kbSection{File: file}
+	var text []string
+	flush := func() {
+		current.Text = strings.TrimSpace(strings.Join(text, "\n"))
+		if current.Heading != "" || current.Text != "" {
+			sections = append(sections, current)
+		}
+		text = nil
+	}
+
+	fenced := false
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+			continue
+		}
+		if !fenced && strings.HasPrefix(trimmed, "#") {
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			heading := strings.TrimSpace(strings.Trim(trimmed[level:], "# "))
+			if level <= 6 && heading != "" && (len(trimmed) == level || trimmed[level] == ' ') {
+				flush()
+				if level == 1 && len(sections) == 0 {
+					title = heading
+				}
+				current = &kbSection{File: file, Heading: heading, Anchor: kbAnchor(heading)}
+				continue
+			}
+		}
+		text = append(text, line)
+	}
+	flush()
+
+	for _, section := range sections {
+		section.Title = title
+		if section.Heading == "" {
+			section.Heading = title
+		}
+		section.terms = make(map[string]int)
+		for _, term := range kbTerms(section.Text) {
+			section.terms[term]++
+		}
+		for _, term := range kbTerms(section.Heading) {
+			section.terms[term] += kbHeadingWeight
+		}
+	}
+	return sections
+}
+
+// kbTerms lowercases words of letters and digits. Han characters have no
+// spaces between words, so each pair of adjacent ones is a term.
+func kbTerms(text string) []string {
+	var terms []string
+	var word []rune
+	var han []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			terms = append(terms, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		if len(han) == 1 {
+			terms = append(terms, string(han))
+		}
+		for i := 0; i+1 < len(han); i++ {
+			terms = append(terms, string(han[i:i+2]))
+		}
+		han = han[:0]
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return terms
+}
+
+// kbAnchor returns the anchor git hosting sites give a heading in the
+// rendered markdown.
+func kbAnchor(heading string) string {
+	var anchor []rune
+	for _, r := range strings.ToLower(heading) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			anchor = append(anchor, r)
+		case r == ' ':
+			anchor = append(anchor, '-')
+		}
+	}
+	return string(anchor)
+}
+
+// kbAbstract strips the markdown markup from the start of the section text.
+func kbAbstract(text string) string {
+	replacer := strings.NewReplacer("`", "", "*", "", ">", "", "|", " ")
+	abstract := []rune(strings.Join(strings.Fields(replacer.Replace(text)), " "))
+	if len(abstract) > kbAbstractLength {
+		abstract = append(abstract[:kbAbstractLength], '…')
+	}
+	return string(abstract)
+}
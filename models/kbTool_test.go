@@ -0,0 +1,58 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const redisRunbook = "# Redis\n\nRunbooks of the redis cluster.\n\n" +
+	"## Failover\n\nPromote the replica when the master is down:\n\n```\n# not a heading\nredis-cli failover\n```\n\n" +
+	"## Backup\n\nThe rdb file is copied to s3 every night.\n"
+
+const mysqlRunbook = "# MySQL 主从切换\n\n主库宕机时提升从库，然后修改应用的数据库地址。\n"
+
+func TestKbIndex(t *testing.T) {
+	Convey("Subject: Search the runbooks\n", t, func() {
+		dir, err := ioutil.TempDir("", "runbooks")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(os.MkdirAll(filepath.Join(dir, "db"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "redis.md"), []byte(redisRunbook), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "db", "mysql.md"), []byte(mysqlRunbook), 0644), ShouldBeNil)
+		idx := &kbIndex{}
+
+		Convey("The section matching every key word ranks first", func() {
+			matches, err := idx.search(dir, "redis failover", 4)
+			So(err, ShouldBeNil)
+			So(len(matches), ShouldBeGreaterThan, 1)
+			So(matches[0].section.File, ShouldEqual, "redis.md")
+			So(matches[0].section.Heading, ShouldEqual, "Failover")
+			So(matches[0].section.Anchor, ShouldEqual, "failover")
+			So(matches[0].section.Text, ShouldContainSubstring, "# not a heading")
+		})
+
+		Convey("Chinese key words are matched", func() {
+			matches, err := idx.search(dir, "主从切换", 4)
+			So(err, ShouldBeNil)
+			So(len(matches), ShouldEqual, 1)
+			So(matches[0].section.File, ShouldEqual, "db/mysql.md")
+			So(matches[0].section.Title, ShouldEqual, "MySQL 主从切换")
+		})
+
+		Convey("The index is rebuilt when a runbook changes", func() {
+			matches, _ := idx.search(dir, "kafka", 4)
+			So(matches, ShouldBeEmpty)
+			later := time.Now().Add(time.Second)
+			path := filepath.Join(dir, "kafka.md")
+			So(ioutil.WriteFile(path, []byte("# Kafka\n\nRebalance the partitions.\n"), 0644), ShouldBeNil)
+			So(os.Chtimes(path, later, later), ShouldBeNil)
+			matches, _ = idx.search(dir, "kafka", 4)
+			So(len(matches), ShouldEqual, 1)
+		})
+	})
+}
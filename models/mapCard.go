@@ -0,0 +1,346 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/httplib"
+)
+
+const (
+	MapReplyText = "text"
+	MapReplyCard = "card"
+
+	// MapDirectionsURL opens a route in the Google Maps app, else the web map
+	MapDirectionsURL = "https://www.google.com/maps/dir/?api=1"
+	// MapCardImageSize is the size of the static map, as large as the
+	// thumbnail of a news message
+	MapCardImageSize = "360x200"
+
+	// MapImagePath serves the static maps of the route cards
+	MapImagePath = "/map/image"
+	// MapImageSignature is the query param signing the map of a card
+	MapImageSignature = "sig"
+
+	// staticMapBreaker is the circuit breaker of the static map service
+	staticMapBreaker = "staticmap"
+	// how often the static map service is probed before a card is sent
+	staticMapProbeInterval = time.Minute
+)
+
+var (
+	ErrNoStaticMap       = errors.New("static map service is not configured")
+	ErrMapImageSignature = errors.New("map image is not signed by the bot")
+
+	// how long the probe of the static map service may take before the text
+	// of a route is sent instead
+	staticMapTimeout = time.Duration(beego.AppConfig.DefaultInt("staticmaptimeout", 1500)) * time.Millisecond
+
+	// the secret signing the map images of the cards
+	mapImageSecret = newMapImageSecret()
+
+	// the last probe of the static map service
+	staticMapProbe struct {
+		sync.Mutex
+		checked time.Time
+		err     error
+	}
+)
+
+// RouteReply runs action, which plans routes like Directions and GoTo, and
+// replies with the card of the first route if the user prefers cards. The
+// text of action is the reply when no route was planned or the map image
+// can't be drawn.
+func (m *MapTool) RouteReply(action func(*MapTool) TextResponse) interface{} {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	style, err := m.replyStyle()
+	if err != nil {
+		textResp.Content = err.Error()
+		return textResp
+	}
+
+	m.planned = nil
+	textResp = action(m)
+	if style != MapReplyCard || m.planned == nil {
+		return textResp
+	}
+	card, err := m.routeCard(*m.planned)
+	if err != nil {
+		beego.Info("Route card is unavailable, replying with text. User:", m.UserID, "Error:", err)
+		return textResp
+	}
+	return card
+}
+
+// routeReply makes action a pick action replying like RouteReply.
+func routeReply(action func(*MapTool) TextResponse) func(*MapTool) interface{} {
+	return func(t *MapTool) interface{} {
+		return t.RouteReply(action)
+	}
+}
+
+// SetReply saves Reply as the default way the user gets routes.
+func (m *MapTool) SetReply() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	if !validMapReply(m.Reply) {
+		textResp.Content = fmt.Sprintf("不支持的回复方式%s，可选：%s, %s", m.Reply, MapReplyText, MapReplyCard)
+		return textResp
+	}
+	if err := SetUserConfig(m.UserID, "mapreply", m.Reply); err != nil {
+		textResp.Content = fmt.Sprintf("设置路线回复方式失败。")
+		return textResp
+	}
+	beego.Info("Set user route reply:", m.UserID, m.Reply)
+	if m.Reply == MapReplyCard {
+		textResp.Content = fmt.Sprintf("路线将以图文卡片回复，回复route N查看详细路线。")
+	} else {
+		textResp.Content = fmt.Sprintf("路线将以文字回复。")
+	}
+	return textResp
+}
+
+// replyStyle returns Reply, else the default of the user, else mapreply of
+// the config, text by default.
+func (m *MapTool) replyStyle() (string, error) {
+	style := m.Reply
+	if style == "" {
+		style = GetUserConfig(m.UserID, "mapreply")
+	}
+	if style == "" {
+		style = beego.AppConfig.DefaultString("mapreply", MapReplyText)
+	}
+	if !validMapReply(style) {
+		return "", fmt.Errorf("不支持的回复方式%s，可选：%s, %s", style, MapReplyText, MapReplyCard)
+	}
+	return style, nil
+}
+
+func validMapReply(style string) bool {
+	return style == MapReplyText || style == MapReplyCard
+}
+
+// routeCard is a news message of the first planned route: its summary, the
+// static map of the route and a link opening it in a map app.
+func (m *MapTool) routeCard(planned plannedRoutes) (NewsResponse, error) {
+	var newsResp NewsResponse
+	newsResp.MsgType = MsgTypeNews
+	route := planned.Routes[0]
+	leg := route.Legs[0]
+
+	params, err := staticMapParams(route)
+	if err != nil {
+		return newsResp, err
+	}
+	image, err := routeImageURL(params)
+	if err != nil {
+		return newsResp, err
+	}
+	if err := checkStaticMap(params); err != nil {
+		return newsResp, err
+	}
+
+	origin, destination := m.routeEnds(leg)
+	title := fmt.Sprintf("%s路线：%s → %s", mapModeNames[planned.Mode], origin, destination)
+	description := planned.TripTime + routeSummary(route, planned.Mode)
+	if len(planned.Routes) > 1 {
+		description += fmt.Sprintf("\n共有%d条路线，回复route N查看详细路线。", len(planned.Routes))
+	} else {
+		description += fmt.Sprintf("\n回复route 1查看详细路线。")
+	}
+	link := MapDirectionsURL + "&" + url.Values{
+		"origin":               {leg.StartAddress},
+		"origin_place_id":      {planned.OriginID},
+		"destination":          {leg.EndAddress},
+		"destination_place_id": {planned.DestinationID},
+		"travelmode":           {planned.Mode},
+	}.Encode()
+
+	item := Item{Title: title, Description: description, Url: link, PicUrl: image}
+	newsResp.Articles = append(newsResp.Articles, &item)
+	newsResp.ArticleCount = len(newsResp.Articles)
+	beego.Info("Route card:", m.UserID, title)
+	return newsResp, nil
+}
+
+// routeEnds names the origin and destination of the route as the user did.
+func (m *MapTool) routeEnds(leg *Leg) (origin string, destination string) {
+	origin, destination = m.Origin, m.Destination
+	if origin == "" {
+		if location, ok := GetCurrentLocation(m.UserID); ok {
+			origin = location.Label
+		}
+	}
+	if origin == "" {
+		origin = leg.StartAddress
+	}
+	if destination == "" {
+		destination = m.PlaceName
+	}
+	if destination == "" {
+		destination = leg.EndAddress
+	}
+	return origin, destination
+}
+
+// staticMapParams draws the route on the static map: the path and markers
+// of its ends.
+func staticMapParams(route Route) (url.Values, error) {
+	leg := route.Legs[0]
+	if route.OverviewPolyline.Points == "" && leg.StartLocation.Lat == 0 && leg.StartLocation.Lng == 0 {
+		return nil, errors.New("route has no location")
+	}
+	params := url.Values{"size": {MapCardImageSize}}
+	if route.OverviewPolyline.Points != "" {
+		params.Set("path", "weight:5|color:0x1a73e8ff|enc:"+route.OverviewPolyline.Points)
+	}
+	if leg.StartLocation.Lat != 0 || leg.StartLocation.Lng != 0 {
+		params.Add("markers", "color:green|label:A|"+leg.StartLocation.String())
+		params.Add("markers", "color:red|label:B|"+leg.EndLocation.String())
+	}
+	return params, nil
+}
+
+// routeImageURL is the image of the route served by MapImagePath of siteurl,
+// the address users reach the bot at. The params are signed, so the image
+// endpoint draws only the maps of the bot while the key of staticmapurl
+// stays on the server.
+func routeImageURL(params url.Values) (string, error) {
+	site := beego.AppConfig.String("siteurl")
+	if beego.AppConfig.String("staticmapurl") == "" || site == "" {
+		return "", ErrNoStaticMap
+	}
+	signed := url.Values{MapImageSignature: {signMapImage(params)}}
+	return strings.TrimSuffix(site, "/") + MapImagePath + "?" + params.Encode() + "&" + signed.Encode(), nil
+}
+
+// staticMapURL adds the params to the url of staticmapurl, e.g.
+// https://maps.googleapis.com/maps/api/staticmap?key=KEY
+func staticMapURL(params url.Values) (string, error) {
+	base := beego.AppConfig.String("staticmapurl")
+	if base == "" {
+		return "", ErrNoStaticMap
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for key, values := range params {
+		q[key] = values
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func signMapImage(params url.Values) string {
+	mac := hmac.New(sha256.New, mapImageSecret)
+	mac.Write([]byte(params.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newMapImageSecret returns staticmapsecret, else the app secret, else a
+// random secret valid until the bot restarts.
+func newMapImageSecret() []byte {
+	if secret := beego.AppConfig.String("staticmapsecret"); secret != "" {
+		return []byte(secret)
+	}
+	if appSecret != "" {
+		return []byte(appSecret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// StaticMapImage fetches the image of a route card, given the query of its
+// url. Queries not signed by routeImageURL are ErrMapImageSignature.
+func StaticMapImage(query url.Values) ([]byte, string, error) {
+	params := url.Values{}
+	for key, values := range query {
+		params[key] = values
+	}
+	signature := params.Get(MapImageSignature)
+	params.Del(MapImageSignature)
+	if !hmac.Equal([]byte(signature), []byte(signMapImage(params))) {
+		return nil, "", ErrMapImageSignature
+	}
+	return fetchStaticMap(params)
+}
+
+func fetchStaticMap(params url.Values) ([]byte, string, error) {
+	breaker := GetCircuitBreaker(staticMapBreaker)
+	if err := breaker.Allow(); err != nil {
+		return nil, "", err
+	}
+	image, contentType, err := getStaticMap("GET", params, DefaultAPITimeout)
+	breaker.Record(err)
+	return image, contentType, err
+}
+
+// checkStaticMap tells whether the static map service is up, so no card is
+// sent with a broken image. While the circuit of the service is closed, a
+// HEAD probe is made at most once in staticMapProbeInterval. A card costs no
+// more than staticMapTimeout, and nothing while the circuit is open.
+func checkStaticMap(params url.Values) error {
+	breaker := GetCircuitBreaker(staticMapBreaker)
+	staticMapProbe.Lock()
+	defer staticMapProbe.Unlock()
+	if breaker.Status().State == BreakerClosed && time.Since(staticMapProbe.checked) < staticMapProbeInterval {
+		return staticMapProbe.err
+	}
+	if err := breaker.Allow(); err != nil {
+		return err
+	}
+	_, _, err := getStaticMap("HEAD", params, staticMapTimeout)
+	breaker.Record(err)
+	staticMapProbe.checked, staticMapProbe.err = time.Now(), err
+	return err
+}
+
+// getStaticMap requests the image of params from the static map service.
+// The body is read for GET only.
+func getStaticMap(method string, params url.Values, timeout time.Duration) ([]byte, string, error) {
+	image, err := staticMapURL(params)
+	if err != nil {
+		return nil, "", err
+	}
+	req := httplib.NewBeegoRequest(image, method)
+	req.SetTimeout(timeout, timeout)
+	resp, err := req.Response()
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	// the url carries the key of the service, leave it out of the logs
+	service := strings.SplitN(image, "?", 2)[0]
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", &APIStatusError{Method: method, URL: service, StatusCode: resp.StatusCode}
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("%s: not an image: %s", service, contentType)
+	}
+	if method != "GET" {
+		return nil, contentType, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, contentType, nil
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const cardRoutes = `{"Routes": [
+	{"overview_polyline": {"points": "_p~iF~ps|U_ulLnnqC"}, "legs": [{"duration": {"text": "30分钟", "value": 1800}, "distance": {"text": "9 公里", "value": 9000},
+		"start_address": "杭州东站", "end_address": "西湖",
+		"start_location": {"lat": 30.29, "lng": 120.21}, "end_location": {"lat": 30.25, "lng": 120.15}}]},
+	{"legs": [{"duration": {"text": "40分钟", "value": 2400}, "distance": {"text": "11 公里", "value": 11000}}]}
+]}`
+
+func TestRouteCard(t *testing.T) {
+	Convey("Subject: Reply routes with a news card\n", t, func() {
+		done := useTempUserConfig("")
+		defer done()
+		_, closeMap := newFakeMap(cardRoutes, map[string]string{
+			"杭州东站": `[{"name": "杭州东站", "place_id": "cardeast", "formatted_address": "杭州东站"}]`,
+			"西湖":   `[{"name": "西湖", "place_id": "cardlake", "formatted_address": "西湖"}]`,
+		})
+		defer closeMap()
+
+		imageUp := true
+		probes := 0
+		var image url.Values
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" {
+				probes++
+			}
+			if !imageUp {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			image = r.URL.Query()
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		}))
+		defer server.Close()
+		beego.AppConfig.Set("staticmapurl", server.URL+"/staticmap?key=secret")
+		beego.AppConfig.Set("siteurl", "https://angel.example.com/")
+		defer beego.AppConfig.Set("staticmapurl", "")
+		defer beego.AppConfig.Set("siteurl", "")
+		staticMapProbe.checked = time.Time{}
+
+		var req Request
+		req.FromUserName = "carduser"
+		var m MapTool
+		m.NewTool(req)
+		m.Origin, m.Destination = "杭州东站", "西湖"
+
+		Convey("Routes are text by default", func() {
+			reply, ok := m.RouteReply((*MapTool).Directions).(TextResponse)
+			So(ok, ShouldBeTrue)
+			So(reply.Content, ShouldStartWith, "共有2条路线")
+		})
+
+		Convey("The card shows the summary, the map and a link", func() {
+			m.Reply = MapReplyCard
+			reply, ok := m.RouteReply((*MapTool).Directions).(NewsResponse)
+			So(ok, ShouldBeTrue)
+			So(reply.ArticleCount, ShouldEqual, 1)
+			card := reply.Articles[0]
+			So(card.Title, ShouldEqual, "公交路线：杭州东站 → 西湖")
+			So(card.Description, ShouldEqual, "30分钟，9 公里，无需换乘，步行0米\n共有2条路线，回复route N查看详细路线。")
+			So(card.PicUrl, ShouldStartWith, "https://angel.example.com"+MapImagePath+"?")
+			So(card.PicUrl, ShouldNotContainSubstring, "secret")
+			So(probes, ShouldEqual, 1)
+
+			pic, err := url.Parse(card.PicUrl)
+			So(err, ShouldBeNil)
+			body, contentType, err := StaticMapImage(pic.Query())
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "png")
+			So(contentType, ShouldEqual, "image/png")
+			So(image.Get("key"), ShouldEqual, "secret")
+			So(image.Get("path"), ShouldEndWith, "enc:_p~iF~ps|U_ulLnnqC")
+			So(image["markers"], ShouldHaveLength, 2)
+
+			forged := pic.Query()
+			forged.Set("size", "2000x2000")
+			_, _, err = StaticMapImage(forged)
+			So(err, ShouldEqual, ErrMapImageSignature)
+
+			// the service is probed once a minute at most
+			m.RouteReply((*MapTool).Directions)
+			So(probes, ShouldEqual, 1)
+
+			link, err := url.Parse(card.Url)
+			So(err, ShouldBeNil)
+			So(card.Url, ShouldStartWith, MapDirectionsURL)
+			So(link.Query().Get("origin_place_id"), ShouldEqual, "cardeast")
+			So(link.Query().Get("destination_place_id"), ShouldEqual, "cardlake")
+			So(link.Query().Get("travelmode"), ShouldEqual, MapModeTransit)
+		})
+
+		Convey("The saved reply style is used", func() {
+			m.Reply = "image"
+			So(m.SetReply().Content, ShouldStartWith, "不支持的回复方式image")
+			m.Reply = MapReplyCard
+			So(m.SetReply().Content, ShouldStartWith, "路线将以图文卡片回复")
+
+			var other MapTool
+			other.NewTool(req)
+			other.Origin, other.Destination = "杭州东站", "西湖"
+			_, ok := other.RouteReply((*MapTool).Directions).(NewsResponse)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("Text is sent when the map is unavailable", func() {
+			m.Reply = MapReplyCard
+			imageUp = false
+			reply, ok := m.RouteReply((*MapTool).Directions).(TextResponse)
+			So(ok, ShouldBeTrue)
+			So(reply.Content, ShouldStartWith, "共有2条路线")
+
+			imageUp = true
+			beego.AppConfig.Set("siteurl", "")
+			_, ok = m.RouteReply((*MapTool).Directions).(TextResponse)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("Failed directions reply with the text", func() {
+			m.Reply = MapReplyCard
+			m.Destination = "nowhere"
+			reply, ok := m.RouteReply((*MapTool).Directions).(TextResponse)
+			So(ok, ShouldBeTrue)
+			So(reply.Content, ShouldEqual, "查找地点失败，请尝试其他地点关键词。")
+		})
+	})
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// tags of the instructions html that start a new line
+var instructionBreaks = map[string]bool{"div": true, "br": true, "p": true, "li": true}
+
+// plainInstructions turns the html_instructions of a step into plain text.
+// Bold and other inline markup is dropped, divs and line breaks start new
+// lines and entities are decoded, e.g.
+// 向<b>东</b>出发<div style="font-size:0.9em">目的地在右侧</div>
+// becomes 向东出发 and 目的地在右侧 on the next line.
+func plainInstructions(instructions string) string {
+	var lines []string
+	var line strings.Builder
+	flush := func() {
+		if text := strings.Join(strings.Fields(line.String()), " "); text != "" {
+			lines = append(lines, text)
+		}
+		line.Reset()
+	}
+
+	z := html.NewTokenizer(strings.NewReader(instructions))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			flush()
+			return strings.Join(lines, "\n")
+		case html.TextToken:
+			line.Write(z.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			if instructionBreaks[string(name)] {
+				flush()
+			}
+		}
+	}
+}
+
+// indentLines indents the lines of text after the first by prefix.
+func indentLines(text string, prefix string) string {
+	return strings.Replace(text, "\n", "\n"+prefix, -1)
+}
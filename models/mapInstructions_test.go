@@ -0,0 +1,54 @@
+package models
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var update = flag.Bool("update", false, "update the golden files of testdata")
+
+func TestPlainInstructions(t *testing.T) {
+	Convey("Subject: Instructions as plain text\n", t, func() {
+		So(plainInstructions("向<b>东</b>出发"), ShouldEqual, "向东出发")
+		So(plainInstructions(`向<b>右</b>转<div style="font-size:0.9em">目的地在右侧</div>`), ShouldEqual, "向右转\n目的地在右侧")
+		So(plainInstructions("Ben &amp; Jerry&#39;s&nbsp;&gt;<br/>Exit  C"), ShouldEqual, "Ben & Jerry's >\nExit C")
+		So(plainInstructions("<div></div>"), ShouldEqual, "")
+		So(indentLines("a\nb", "    "), ShouldEqual, "a\n    b")
+	})
+}
+
+// TestFormatRouteGolden formats the recorded responses of testdata/directions
+// and compares them with the .golden files, rewritten by go test -update.
+func TestFormatRouteGolden(t *testing.T) {
+	Convey("Subject: Format recorded directions\n", t, func() {
+		files, err := filepath.Glob(filepath.Join("testdata", "directions", "*.json"))
+		So(err, ShouldBeNil)
+		So(files, ShouldNotBeEmpty)
+
+		for _, file := range files {
+			mode := strings.TrimSuffix(filepath.Base(file), ".json")
+			data, err := ioutil.ReadFile(file)
+			So(err, ShouldBeNil)
+			var response Routes
+			So(json.Unmarshal(data, &response), ShouldBeNil)
+			So(response.Routes, ShouldNotBeEmpty)
+
+			got := formatRoute(response.Routes[0], mode)
+			So(got, ShouldNotContainSubstring, "<")
+
+			golden := strings.TrimSuffix(file, ".json") + ".golden"
+			if *update {
+				So(ioutil.WriteFile(golden, []byte(got), 0644), ShouldBeNil)
+			}
+			want, err := ioutil.ReadFile(golden)
+			So(err, ShouldBeNil)
+			So(got, ShouldEqual, string(want))
+		}
+	})
+}
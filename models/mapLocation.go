@@ -0,0 +1,263 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"googlemaps.github.io/maps"
+)
+
+const (
+	// MapNearbyMax is the most nearby places listed, as many as a news
+	// message holds
+	MapNearbyMax = 8
+	// MapNearbyRadius is the search radius in meters without nearbyradius
+	MapNearbyRadius = 1000
+	// MapNearbyMaxRadius is the largest radius the nearby search accepts
+	MapNearbyMaxRadius = 50000
+
+	// MapPlaceURL opens a place in Google Maps
+	MapPlaceURL = "https://www.google.com/maps/search/?api=1"
+)
+
+var (
+	ErrNoNearbyCenter = errors.New("请先发送位置信息，或使用--near PLACE指定搜索的中心。")
+
+	// how long a location sent by a user stays the current location
+	locationTTL = time.Duration(beego.AppConfig.DefaultInt("locationttl", 1800)) * time.Second
+
+	currentLocations = struct {
+		sync.Mutex
+		m map[string]CurrentLocation
+	}{m: make(map[string]CurrentLocation)}
+)
+
+// CurrentLocation is the last location message of a user.
+type CurrentLocation struct {
+	Latlng string
+	Label  string
+	At     time.Time
+}
+
+// SetCurrentLocation remembers the location the user sent.
+func SetCurrentLocation(userID string, location CurrentLocation) {
+	currentLocations.Lock()
+	currentLocations.m[userID] = location
+	currentLocations.Unlock()
+}
+
+// GetCurrentLocation returns the location the user sent within locationTTL.
+func GetCurrentLocation(userID string) (CurrentLocation, bool) {
+	currentLocations.Lock()
+	defer currentLocations.Unlock()
+	location, ok := currentLocations.m[userID]
+	if !ok {
+		return location, false
+	}
+	if time.Since(location.At) > locationTTL {
+		delete(currentLocations.m, userID)
+		return location, false
+	}
+	return location, true
+}
+
+// HasCurrentLocation tells whether the user sent a location within
+// locationTTL.
+func HasCurrentLocation(userID string) bool {
+	_, ok := GetCurrentLocation(userID)
+	return ok
+}
+
+// FromHereDestination returns the destination of a message of a user with a
+// current location: the name of a saved place, or PLACE of go PLACE. Other
+// messages are not taken for places, for chat and typos not to be searched.
+func FromHereDestination(userID string, content string) (string, bool) {
+	if !HasCurrentLocation(userID) {
+		return "", false
+	}
+	fields := strings.Fields(content)
+	if len(fields) == 2 && fields[0] == MapGoCommand {
+		return fields[1], true
+	}
+	if len(fields) == 1 {
+		if _, ok := GetSavedPlace(userID, fields[0]); ok {
+			return fields[0], true
+		}
+	}
+	return "", false
+}
+
+// SetLocation remembers Latlng and its label Origin as the current location
+// of the user and offers the saved places as destinations.
+func (m *MapTool) SetLocation() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	SetCurrentLocation(m.UserID, CurrentLocation{Latlng: m.Latlng, Label: m.Origin, At: time.Now()})
+	beego.Info("Set user current location:", m.UserID, m.Latlng, m.Origin)
+
+	textResp.Content = fmt.Sprintf("已记录当前位置：%s\n", m.Origin)
+	places := SavedPlaces(m.UserID)
+	if len(places) > 0 {
+		textResp.Content += "回复地点名称获取从这里出发的路线：\n"
+		for _, place := range places {
+			textResp.Content += fmt.Sprintf("    %s：%s\n", place.Name, place.Address)
+		}
+		textResp.Content += "也可以回复go PLACE前往任意地点，"
+	} else {
+		textResp.Content += "回复go PLACE获取从这里出发的路线，"
+	}
+	textResp.Content += fmt.Sprintf("或使用map nearby KEYWORD搜索附近的地点。")
+	return textResp
+}
+
+// Nearby lists the places matching Place within Radius of Near, or of the
+// current location of the user, nearest first. The reply is a news message,
+// else the text explaining why there is none.
+func (m *MapTool) Nearby() (NewsResponse, TextResponse) {
+	var newsResp NewsResponse
+	var textResp TextResponse
+	newsResp.MsgType = MsgTypeNews
+	textResp.MsgType = MsgTypeText
+
+	radius := m.Radius
+	if radius == 0 {
+		radius = beego.AppConfig.DefaultInt("nearbyradius", MapNearbyRadius)
+	}
+	if radius < 1 || radius > MapNearbyMaxRadius {
+		textResp.Content = fmt.Sprintf("搜索半径应为1到%d米。", MapNearbyMaxRadius)
+		return newsResp, textResp
+	}
+	latlng, label, err := m.nearbyCenter()
+	if err == ErrNoNearbyCenter {
+		textResp.Content = err.Error()
+		return newsResp, textResp
+	}
+	if err != nil {
+		textResp.Content = m.placeErrorReply(err, "查找地点失败，请尝试其他地点关键词。", func(t *MapTool) interface{} {
+			news, text := t.Nearby()
+			if text.Content != "" {
+				return text
+			}
+			return news
+		})
+		return newsResp, textResp
+	}
+	center, err := maps.ParseLatLng(latlng)
+	if err != nil {
+		textResp.Content = fmt.Sprintf("无法识别位置%s。", latlng)
+		return newsResp, textResp
+	}
+
+	results, err := getPlacesNearby(m.Context(), m.Place, latlng, radius)
+	if err != nil {
+		textResp.Content = placeErrorMessage(err, fmt.Sprintf("%s附近%d米内没有找到%s。", label, radius, m.Place))
+		return newsResp, textResp
+	}
+	distances := make(map[string]float64)
+	for _, result := range results {
+		distances[result.PlaceID] = distanceMeters(center, result.Geometry.Location)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return distances[results[i].PlaceID] < distances[results[j].PlaceID]
+	})
+	if len(results) > MapNearbyMax {
+		results = results[:MapNearbyMax]
+	}
+
+	for _, result := range results {
+		title := result.Name + " " + formatDistance(distances[result.PlaceID])
+		if result.Rating > 0 {
+			title += fmt.Sprintf(" ★%.1f", result.Rating)
+		}
+		if status := openStatus(result); status != "" {
+			title += " " + status
+		}
+		address := result.Vicinity
+		if address == "" {
+			address = result.FormattedAddress
+		}
+		link := MapPlaceURL + "&query=" + url.QueryEscape(result.Name) + "&query_place_id=" + url.QueryEscape(result.PlaceID)
+		item := Item{Title: title, Description: address, Url: link, PicUrl: result.Icon}
+		newsResp.Articles = append(newsResp.Articles, &item)
+	}
+	newsResp.ArticleCount = len(newsResp.Articles)
+	beego.Info("Nearby places:", m.UserID, label, m.Place, radius, "Count:", newsResp.ArticleCount)
+	return newsResp, textResp
+}
+
+// nearbyCenter returns the location of Near, else the current location of
+// the user, and its name.
+func (m *MapTool) nearbyCenter() (latlng string, label string, err error) {
+	if m.Near == "" {
+		location, ok := GetCurrentLocation(m.UserID)
+		if !ok {
+			return "", "", ErrNoNearbyCenter
+		}
+		return location.Latlng, location.Label, nil
+	}
+	place, err := m.resolvePlaceCandidate(m.Near)
+	if err != nil {
+		return "", "", err
+	}
+	if place.Latlng == "" {
+		// saved before locations were kept, look the address up
+		found, err := searchPlace(m.Context(), place.Address, "")
+		if ambiguous, ok := err.(*AmbiguousPlaceError); ok {
+			found, err = ambiguous.Candidates[0], nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		place.Latlng = found.Latlng
+	}
+	if place.Latlng == "" {
+		return "", "", errors.New("place has no location")
+	}
+	return place.Latlng, m.Near, nil
+}
+
+// distanceMeters is the great-circle distance between a and b.
+func distanceMeters(a maps.LatLng, b maps.LatLng) float64 {
+	const earthRadius = 6371000
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat, dLng := lat2-lat1, (b.Lng-a.Lng)*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func formatDistance(meters float64) string {
+	if meters < 1000 {
+		return fmt.Sprintf("%d米", int(meters+0.5))
+	}
+	return fmt.Sprintf("%.1f公里", meters/1000)
+}
+
+// openStatus tells whether the place is open now, empty if unknown.
+func openStatus(result maps.PlacesSearchResult) string {
+	switch {
+	case result.BusinessStatus == "CLOSED_TEMPORARILY":
+		return "暂停营业"
+	case result.OpeningHours == nil || result.OpeningHours.OpenNow == nil:
+		return ""
+	case *result.OpeningHours.OpenNow:
+		return "营业中"
+	}
+	return "已打烊"
+}
+
+// locationPlaceID finds the place of a location by its label around it,
+// else by the label alone.
+func (m *MapTool) locationPlaceID(location CurrentLocation) (string, error) {
+	placeID, err := getPleaceNearby(m.Context(), location.Label, location.Latlng)
+	if err != nil {
+		placeID, _, err = m.resolvePlace(location.Label)
+	}
+	return placeID, err
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCurrentLocation(t *testing.T) {
+	Convey("Subject: Directions from the location the user sent\n", t, func() {
+		done := useTempUserConfig("[walker]\nid=homeid\naddress=滨江区江南大道\n")
+		defer done()
+		last, closeMap := newFakeMap(`{"Routes": [{"legs": [{"duration": {"text": "30分钟"}, "distance": {"text": "8 公里"}}]}]}`,
+			map[string]string{
+				"nearby:西湖文化广场": `[{"name": "西湖文化广场", "place_id": "here"}]`,
+				"nearby:咖啡": `[{"name": "星巴克", "place_id": "sb", "vicinity": "中山北路", "rating": 4.5,
+						"geometry": {"location": {"lat": 30.28, "lng": 120.16}}, "opening_hours": {"open_now": false}},
+					{"name": "瑞幸", "place_id": "lk", "vicinity": "环城北路", "icon": "https://maps.gstatic.com/cafe.png",
+						"geometry": {"location": {"lat": 30.272, "lng": 120.16}}, "opening_hours": {"open_now": true}}]`,
+				"武林广场": `[{"name": "武林广场", "place_id": "wl", "formatted_address": "拱墅区武林广场",
+					"geometry": {"location": {"lat": 30.2714, "lng": 120.1635}}}]`,
+			})
+		defer closeMap()
+		var req Request
+		req.FromUserName = "walker"
+		var m MapTool
+		m.NewTool(req)
+
+		Convey("Without a location there is no origin", func() {
+			So(HasCurrentLocation("walker"), ShouldBeFalse)
+			m.Place = "咖啡"
+			_, text := m.Nearby()
+			So(text.Content, ShouldEqual, ErrNoNearbyCenter.Error())
+		})
+
+		Convey("A location message offers the saved places", func() {
+			m.Latlng, m.Origin = "30.27,120.16", "西湖文化广场"
+			So(m.SetLocation().Content, ShouldStartWith, "已记录当前位置：西湖文化广场\n回复地点名称获取从这里出发的路线：\n    home：滨江区江南大道\n")
+			So(HasCurrentLocation("walker"), ShouldBeTrue)
+
+			Convey("and only saved place names or go PLACE are taken for destinations", func() {
+				for content, destination := range map[string]string{"home": "home", "go 武林广场": "武林广场"} {
+					got, ok := FromHereDestination("walker", content)
+					So(ok, ShouldBeTrue)
+					So(got, ShouldEqual, destination)
+				}
+				for _, content := range []string{"谢谢", "ok", "hellp", "go", "go to 武林广场"} {
+					_, ok := FromHereDestination("walker", content)
+					So(ok, ShouldBeFalse)
+				}
+				_, ok := FromHereDestination("runner", "home")
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("and replies with a place name plan the route from there", func() {
+				var next MapTool
+				next.NewTool(req)
+				next.Destination = "home"
+				So(next.Directions().Content, ShouldContainSubstring, "预计用时30分钟")
+				So(last.Get("origin"), ShouldEqual, "here")
+				So(last.Get("destination"), ShouldEqual, "homeid")
+
+				next.Destination = "武林广场"
+				next.Directions()
+				So(last.Get("destination"), ShouldEqual, "wl")
+			})
+
+			Convey("and map nearby searches around it, nearest first", func() {
+				var next MapTool
+				next.NewTool(req)
+				next.Place = "咖啡"
+				places := placeCache.Stats().Size
+				news, text := next.Nearby()
+				// the open status is kept briefly, apart from the places
+				So(placeCache.Stats().Size, ShouldEqual, places)
+				So(nearbyCache.TTL, ShouldBeLessThan, placeCache.TTL)
+				So(text.Content, ShouldEqual, "")
+				So(last.Get("latlng"), ShouldEqual, "30.27,120.16")
+				So(last.Get("radius"), ShouldEqual, "1000")
+				So(news.ArticleCount, ShouldEqual, 2)
+				So(news.Articles[0].Title, ShouldEqual, "瑞幸 222米 营业中")
+				So(news.Articles[0].PicUrl, ShouldEqual, "https://maps.gstatic.com/cafe.png")
+				So(news.Articles[0].Url, ShouldEqual, MapPlaceURL+"&query=%E7%91%9E%E5%B9%B8&query_place_id=lk")
+				So(news.Articles[1].Title, ShouldEqual, "星巴克 1.1公里 ★4.5 已打烊")
+				So(news.Articles[1].Description, ShouldEqual, "中山北路")
+			})
+
+			Convey("or around a place given with --near", func() {
+				var next MapTool
+				next.NewTool(req)
+				next.Place, next.Near, next.Radius = "咖啡", "武林广场", 500
+				_, text := next.Nearby()
+				So(text.Content, ShouldEqual, "")
+				So(last.Get("latlng"), ShouldEqual, "30.2714,120.1635")
+				So(last.Get("radius"), ShouldEqual, "500")
+
+				next.Radius = 60000
+				_, text = next.Nearby()
+				So(text.Content, ShouldEqual, "搜索半径应为1到50000米。")
+			})
+
+			Convey("until it expires", func() {
+				SetCurrentLocation("walker", CurrentLocation{Latlng: "30.27,120.16", Label: "西湖文化广场", At: time.Now().Add(-locationTTL - time.Second)})
+				So(HasCurrentLocation("walker"), ShouldBeFalse)
+			})
+		})
+
+		Reset(func() {
+			currentLocations.Lock()
+			delete(currentLocations.m, "walker")
+			currentLocations.Unlock()
+		})
+	})
+}
@@ -0,0 +1,302 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+const (
+	// MapHomePlace is the saved place kept in the id and address settings of
+	// the user, before other places could be saved.
+	MapHomePlace = "home"
+
+	// saved places other than home are the settings place.NAME.id,
+	// place.NAME.address and place.NAME.latlng of the user
+	mapPlacePrefix = "place."
+)
+
+var (
+	// how long a request waits for pick N
+	pickTTL = time.Duration(beego.AppConfig.DefaultInt("pickttl", 600)) * time.Second
+
+	// the request of each user waiting for pick N
+	pendingPicks = struct {
+		sync.Mutex
+		m map[string]pendingPick
+	}{m: make(map[string]pendingPick)}
+)
+
+// SavedPlace is a place a user saved under a name. Latlng is empty for places
+// saved before locations were kept.
+type SavedPlace struct {
+	Name    string
+	PlaceID string
+	Address string
+	Latlng  string
+}
+
+// GetSavedPlace returns the place the user saved as name.
+func GetSavedPlace(userID string, name string) (SavedPlace, bool) {
+	name = strings.ToLower(name)
+	idKey, addressKey, latlngKey := savedPlaceKeys(name)
+	place := SavedPlace{Name: name, PlaceID: GetUserConfig(userID, idKey), Address: GetUserConfig(userID, addressKey),
+		Latlng: GetUserConfig(userID, latlngKey)}
+	return place, place.PlaceID != "" && place.Address != ""
+}
+
+// SavedPlaces returns the places of the user, home first and the others by
+// name.
+func SavedPlaces(userID string) []SavedPlace {
+	var places []SavedPlace
+	if home, ok := GetSavedPlace(userID, MapHomePlace); ok {
+		places = append(places, home)
+	}
+	var names []string
+	for key := range UserConfigs(userID) {
+		if strings.HasPrefix(key, mapPlacePrefix) && strings.HasSuffix(key, ".id") {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(key, mapPlacePrefix), ".id"))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if place, ok := GetSavedPlace(userID, name); ok {
+			places = append(places, place)
+		}
+	}
+	return places
+}
+
+// SavePlace saves the place of the user as name, replacing the place saved
+// before.
+func SavePlace(userID string, place SavedPlace) error {
+	idKey, addressKey, latlngKey := savedPlaceKeys(strings.ToLower(place.Name))
+	return SetUserConfigs(userID, map[string]string{idKey: place.PlaceID, addressKey: place.Address, latlngKey: place.Latlng})
+}
+
+// DeleteSavedPlace removes the place the user saved as name. The config
+// cannot remove keys, so the settings are emptied.
+func DeleteSavedPlace(userID string, name string) error {
+	idKey, addressKey, latlngKey := savedPlaceKeys(strings.ToLower(name))
+	return SetUserConfigs(userID, map[string]string{idKey: "", addressKey: "", latlngKey: ""})
+}
+
+func savedPlaceKeys(name string) (string, string, string) {
+	if name == MapHomePlace {
+		return "id", "address", "latlng"
+	}
+	prefix := mapPlacePrefix + name
+	return prefix + ".id", prefix + ".address", prefix + ".latlng"
+}
+
+// validPlaceName tells whether name can be a key of userhome.conf.
+func validPlaceName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ":=#;[]. \t")
+}
+
+// PlaceCandidate is one of the places an ambiguous keyword may mean.
+type PlaceCandidate struct {
+	Name    string
+	PlaceID string
+	Address string
+	Latlng  string
+}
+
+// AmbiguousPlaceError is returned for a keyword matching several places.
+type AmbiguousPlaceError struct {
+	Keyword    string
+	Candidates []PlaceCandidate
+}
+
+func (e *AmbiguousPlaceError) Error() string {
+	return fmt.Sprintf("place %s is ambiguous: %d candidates", e.Keyword, len(e.Candidates))
+}
+
+// pendingPick is a request stopped at an ambiguous keyword. Action runs it
+// again once the user picks one of the candidates.
+type pendingPick struct {
+	Tool      MapTool
+	Action    func(*MapTool) interface{}
+	Ambiguous *AmbiguousPlaceError
+	At        time.Time
+}
+
+// resolvePlace returns the place saved as keyword by the user, else the place
+// the user picked for the keyword, else the place found by the keyword in the
+// region of the user.
+func (m *MapTool) resolvePlace(keyword string) (placeID string, address string, err error) {
+	place, err := m.resolvePlaceCandidate(keyword)
+	return place.PlaceID, place.Address, err
+}
+
+// resolvePlaceCandidate is resolvePlace returning the name and location of
+// the place too.
+func (m *MapTool) resolvePlaceCandidate(keyword string) (PlaceCandidate, error) {
+	if place, ok := GetSavedPlace(m.UserID, keyword); ok {
+		beego.Info("Use saved place:", m.UserID, place.Name, place.PlaceID, place.Address)
+		return PlaceCandidate{Name: place.Name, PlaceID: place.PlaceID, Address: place.Address, Latlng: place.Latlng}, nil
+	}
+	if place, ok := m.picks[keyword]; ok {
+		return place, nil
+	}
+	return searchPlace(m.Context(), keyword, m.region())
+}
+
+// region returns the region the place search of the user is biased to, the
+// mapregion of the config by default.
+func (m *MapTool) region() string {
+	if region := GetUserConfig(m.UserID, "mapregion"); region != "" {
+		return region
+	}
+	return beego.AppConfig.String("mapregion")
+}
+
+// placeError explains a failed place lookup. An ambiguous keyword lists the
+// candidates and keeps action to run again after pick N.
+func (m *MapTool) placeError(err error, notFound string, action func(*MapTool) TextResponse) string {
+	return m.placeErrorReply(err, notFound, func(t *MapTool) interface{} {
+		return action(t)
+	})
+}
+
+// placeErrorReply is placeError for actions replying with a text or a news
+// message.
+func (m *MapTool) placeErrorReply(err error, notFound string, action func(*MapTool) interface{}) string {
+	ambiguous, ok := err.(*AmbiguousPlaceError)
+	if !ok {
+		return placeErrorMessage(err, notFound)
+	}
+	pendingPicks.Lock()
+	// drop the picks nobody made, for the map not to grow with every user
+	for userID, pending := range pendingPicks.m {
+		if time.Since(pending.At) > pickTTL {
+			delete(pendingPicks.m, userID)
+		}
+	}
+	pendingPicks.m[m.UserID] = pendingPick{Tool: *m, Action: action, Ambiguous: ambiguous, At: time.Now()}
+	pendingPicks.Unlock()
+
+	content := fmt.Sprintf("“%s”匹配到多个地点：\n", ambiguous.Keyword)
+	for i, candidate := range ambiguous.Candidates {
+		content += fmt.Sprintf("%d. %s：%s\n", i+1, candidate.Name, candidate.Address)
+	}
+	content += fmt.Sprintf("回复pick N选择地点，或使用map set region设置默认城市。")
+	return content
+}
+
+// Pick continues the request waiting for the user to pick candidate number
+// Choice, if it waits no longer than pickTTL. The reply is the TextResponse or NewsResponse of the request.
+func (m *MapTool) Pick() interface{} {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+
+	pendingPicks.Lock()
+	pending, ok := pendingPicks.m[m.UserID]
+	if ok && time.Since(pending.At) > pickTTL {
+		delete(pendingPicks.m, m.UserID)
+		ok = false
+	}
+	if ok && m.Choice >= 1 && m.Choice <= len(pending.Ambiguous.Candidates) {
+		delete(pendingPicks.m, m.UserID)
+	}
+	pendingPicks.Unlock()
+	if !ok {
+		textResp.Content = fmt.Sprintf("没有需要选择的地点。")
+		return textResp
+	}
+	if m.Choice < 1 || m.Choice > len(pending.Ambiguous.Candidates) {
+		textResp.Content = fmt.Sprintf("地点编号应为1到%d。", len(pending.Ambiguous.Candidates))
+		return textResp
+	}
+
+	candidate := pending.Ambiguous.Candidates[m.Choice-1]
+	beego.Info("User picked place:", m.UserID, pending.Ambiguous.Keyword, candidate.Name, candidate.PlaceID)
+	tool := pending.Tool
+	picks := make(map[string]PlaceCandidate)
+	for keyword, place := range tool.picks {
+		picks[keyword] = place
+	}
+	picks[pending.Ambiguous.Keyword] = candidate
+	tool.picks = picks
+	// the request waiting for the pick is over, continue in this one
+	tool.ctx = m.ctx
+	return pending.Action(&tool)
+}
+
+// SetRegion saves Region as the region the place search of the user is
+// biased to.
+func (m *MapTool) SetRegion() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	if err := SetUserConfig(m.UserID, "mapregion", m.Region); err != nil {
+		textResp.Content = fmt.Sprintf("设置默认城市/地区失败。")
+		return textResp
+	}
+	beego.Info("Set user region:", m.UserID, m.Region)
+	textResp.Content = fmt.Sprintf("默认城市/地区已设置为%s。", m.Region)
+	return textResp
+}
+
+// SavePlace saves the place found by Place as PlaceName.
+func (m *MapTool) SavePlace() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	if !validPlaceName(m.PlaceName) {
+		textResp.Content = fmt.Sprintf("地点名称%s不能包含空格和:=#;[].等字符。", m.PlaceName)
+		return textResp
+	}
+	if _, ok := GetSavedPlace(m.UserID, m.PlaceName); ok {
+		beego.Info("User ", m.UserID, "place", m.PlaceName, "is existed. Overwriting..")
+	}
+
+	place, err := m.resolvePlaceCandidate(m.Place)
+	if err != nil {
+		textResp.Content = m.placeError(err, "保存地点失败，请尝试其他地址关键词。", (*MapTool).SavePlace)
+		return textResp
+	}
+	placeID, address := place.PlaceID, place.Address
+	if err := SavePlace(m.UserID, SavedPlace{Name: m.PlaceName, PlaceID: placeID, Address: address, Latlng: place.Latlng}); err != nil {
+		textResp.Content = fmt.Sprintf("保存地点失败。")
+		return textResp
+	}
+	textResp.Content = fmt.Sprintf("保存地点%s成功：%s", strings.ToLower(m.PlaceName), address)
+	beego.Info("Save user place:", m.UserID, m.PlaceName, placeID, address)
+	return textResp
+}
+
+// Places lists the saved places of the user.
+func (m *MapTool) Places() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	places := SavedPlaces(m.UserID)
+	if len(places) == 0 {
+		textResp.Content = fmt.Sprintf("用户还未保存地点，使用map save NAME Place来保存地点。")
+		return textResp
+	}
+	textResp.Content = fmt.Sprintf("共有%d个地点：\n", len(places))
+	for i, place := range places {
+		textResp.Content += fmt.Sprintf("%d. %s：%s\n", i+1, place.Name, place.Address)
+	}
+	return textResp
+}
+
+// DeletePlace deletes the place saved as PlaceName.
+func (m *MapTool) DeletePlace() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	if _, ok := GetSavedPlace(m.UserID, m.PlaceName); !ok {
+		textResp.Content = fmt.Sprintf("没有名为%s的地点，使用map places查看已保存的地点。", m.PlaceName)
+		return textResp
+	}
+	if err := DeleteSavedPlace(m.UserID, m.PlaceName); err != nil {
+		textResp.Content = fmt.Sprintf("删除地点失败。")
+		return textResp
+	}
+	textResp.Content = fmt.Sprintf("已删除地点%s。", strings.ToLower(m.PlaceName))
+	beego.Info("Delete user place:", m.UserID, m.PlaceName)
+	return textResp
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// useTempUserConfig loads the user settings from a copy of content in a
+// temporary file.
+func useTempUserConfig(content string) func() {
+	dir, _ := ioutil.TempDir("", "userhome")
+	path := filepath.Join(dir, "userhome.conf")
+	ioutil.WriteFile(path, []byte(content), 0644)
+	conf, err := config.NewConfig("ini", path)
+	if err != nil {
+		panic(err)
+	}
+	oldConfig, oldPath := userHomeConfig, userConfigPath
+	userHomeConfig, userConfigPath = conf, path
+	return func() {
+		userHomeConfig, userConfigPath = oldConfig, oldPath
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSavedPlaces(t *testing.T) {
+	Convey("Subject: Named saved places\n", t, func() {
+		done := useTempUserConfig("[user]\nid=homeid\naddress=滨江区江南大道\n")
+		defer done()
+
+		Convey("Home is the place set with map set home", func() {
+			home, ok := GetSavedPlace("user", "home")
+			So(ok, ShouldBeTrue)
+			So(home, ShouldResemble, SavedPlace{Name: "home", PlaceID: "homeid", Address: "滨江区江南大道"})
+		})
+
+		Convey("Places are saved, listed home first and deleted", func() {
+			So(SavePlace("user", SavedPlace{Name: "Office", PlaceID: "officeid", Address: "西湖区文三路"}), ShouldBeNil)
+			So(SavePlace("user", SavedPlace{Name: "gym", PlaceID: "gymid", Address: "上城区"}), ShouldBeNil)
+			office, ok := GetSavedPlace("user", "office")
+			So(ok, ShouldBeTrue)
+			So(office.PlaceID, ShouldEqual, "officeid")
+
+			var req Request
+			req.FromUserName = "user"
+			var m MapTool
+			m.NewTool(req)
+			So(m.Places().Content, ShouldEqual, "共有3个地点：\n1. home：滨江区江南大道\n2. gym：上城区\n3. office：西湖区文三路\n")
+
+			m.PlaceName = "office"
+			So(m.DeletePlace().Content, ShouldEqual, "已删除地点office。")
+			_, ok = GetSavedPlace("user", "office")
+			So(ok, ShouldBeFalse)
+			So(SavedPlaces("user"), ShouldHaveLength, 2)
+
+			// the settings survive a reload of the file
+			conf, err := config.NewConfig("ini", userConfigPath)
+			So(err, ShouldBeNil)
+			So(conf.String("user::place.gym.address"), ShouldEqual, "上城区")
+		})
+
+		Convey("Saved names replace place keywords", func() {
+			var req Request
+			req.FromUserName = "user"
+			var m MapTool
+			m.NewTool(req)
+			placeID, address, err := m.resolvePlace("home")
+			So(err, ShouldBeNil)
+			So(placeID, ShouldEqual, "homeid")
+			So(address, ShouldEqual, "滨江区江南大道")
+		})
+
+		Convey("Names must fit in the config", func() {
+			So(validPlaceName("office"), ShouldBeTrue)
+			So(validPlaceName("公司"), ShouldBeTrue)
+			So(validPlaceName("a::b"), ShouldBeFalse)
+			So(validPlaceName("a.b"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestPlaceDisambiguation(t *testing.T) {
+	Convey("Subject: Pick one of the places a keyword matches\n", t, func() {
+		done := useTempUserConfig("")
+		defer done()
+		last, closeMap := newFakeMap(`{"Routes": [{"legs": [{"duration": {"text": "30分钟"}, "distance": {"text": "8 公里"}}]}]}`,
+			map[string]string{
+				"火车站": `[{"name": "杭州站", "place_id": "hz", "formatted_address": "上城区环城东路"},
+					{"name": "杭州东站", "place_id": "hzd", "formatted_address": "上城区天城路"},
+					{"name": "杭州南站", "place_id": "hzn", "formatted_address": "萧山区"}]`,
+				"中山公园": `[{"name": "中山公园", "place_id": "zsbj", "formatted_address": "北京市东城区"},
+					{"name": "中山公园", "place_id": "zssh", "formatted_address": "上海市长宁区"},
+					{"name": "中山公园地铁站", "place_id": "zss", "formatted_address": "上海市长宁区"},
+					{"name": "中山公园", "place_id": "zsxm", "formatted_address": "厦门市思明区"}]`,
+				"杭州 西湖": `[{"name": "西湖", "place_id": "xh", "formatted_address": "杭州市西湖区"}]`,
+				"武林广场": `[{"name": "武林广场", "place_id": "wl", "formatted_address": "拱墅区武林广场"},
+					{"name": "武林广场地铁站", "place_id": "wls", "formatted_address": "拱墅区"}]`,
+			})
+		defer closeMap()
+		var req Request
+		req.FromUserName = "picker"
+		var m MapTool
+		m.NewTool(req)
+
+		Convey("A single exact name is taken", func() {
+			placeID, _, err := m.resolvePlace("武林广场")
+			So(err, ShouldBeNil)
+			So(placeID, ShouldEqual, "wl")
+		})
+
+		Convey("The best match is taken when no other place has its name", func() {
+			placeID, _, err := m.resolvePlace("火车站")
+			So(err, ShouldBeNil)
+			So(placeID, ShouldEqual, "hz")
+		})
+
+		Convey("Ambiguous keywords list the candidates, and pick N goes on", func() {
+			m.Origin, m.Destination = "中山公园", "武林广场"
+			resp := m.Directions()
+			So(resp.Content, ShouldStartWith, "“中山公园”匹配到多个地点：\n1. 中山公园：北京市东城区\n2. 中山公园：上海市长宁区\n3. 中山公园：厦门市思明区\n")
+
+			var pick MapTool
+			pick.NewTool(req)
+			pick.Choice = 4
+			So(pick.Pick().(TextResponse).Content, ShouldEqual, "地点编号应为1到3。")
+			pick.Choice = 2
+			So(pick.Pick().(TextResponse).Content, ShouldContainSubstring, "预计用时30分钟")
+			So(last.Get("origin"), ShouldEqual, "zssh")
+			So(last.Get("destination"), ShouldEqual, "wl")
+			So(pick.Pick().(TextResponse).Content, ShouldEqual, "没有需要选择的地点。")
+		})
+
+		Convey("Picks expire after pickTTL", func() {
+			m.Origin, m.Destination = "中山公园", "武林广场"
+			m.Directions()
+			pendingPicks.Lock()
+			pending := pendingPicks.m["picker"]
+			pending.At = time.Now().Add(-pickTTL - time.Second)
+			pendingPicks.m["picker"] = pending
+			pendingPicks.Unlock()
+
+			var pick MapTool
+			pick.NewTool(req)
+			pick.Choice = 2
+			So(pick.Pick().(TextResponse).Content, ShouldEqual, "没有需要选择的地点。")
+
+			Convey("and are dropped when others wait", func() {
+				pendingPicks.Lock()
+				pendingPicks.m["picker"] = pending
+				pendingPicks.Unlock()
+				var otherReq Request
+				otherReq.FromUserName = "other picker"
+				var other MapTool
+				other.NewTool(otherReq)
+				other.Origin, other.Destination = "中山公园", "武林广场"
+				other.Directions()
+				pendingPicks.Lock()
+				_, ok := pendingPicks.m["picker"]
+				delete(pendingPicks.m, "other picker")
+				pendingPicks.Unlock()
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("The region of the user biases the search", func() {
+			m.Region = "杭州"
+			So(m.SetRegion().Content, ShouldEqual, "默认城市/地区已设置为杭州。")
+			placeID, _, err := m.resolvePlace("西湖")
+			So(err, ShouldBeNil)
+			So(placeID, ShouldEqual, "xh")
+			So(last.Get("keyword"), ShouldEqual, "杭州 西湖")
+
+			m.resolvePlace("杭州东站")
+			So(last.Get("keyword"), ShouldEqual, "杭州东站")
+		})
+	})
+}
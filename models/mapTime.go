@@ -0,0 +1,183 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+const (
+	// DefaultTimezone is the time zone of users without a timezone setting
+	DefaultTimezone = "Asia/Shanghai"
+)
+
+var (
+	ErrTripTimeConflict = errors.New("--depart和--arrive只能选择其中一个。")
+	ErrArriveNotTransit = errors.New("只有公交路线支持--arrive。")
+)
+
+// TransitTime is a scheduled time of a transit route, in the time zone of the
+// stop. The ops api returns it either as an RFC 3339 string or as the
+// {"text", "time_zone", "value"} object of the Directions API.
+type TransitTime struct {
+	time.Time
+}
+
+func (t *TransitTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		return t.Time.UnmarshalJSON(data)
+	}
+	var v struct {
+		Value    int64  `json:"value"`
+		TimeZone string `json:"time_zone"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	t.Time = time.Unix(v.Value, 0)
+	if v.TimeZone != "" {
+		if loc, err := time.LoadLocation(v.TimeZone); err == nil {
+			t.Time = t.Time.In(loc)
+		}
+	}
+	return nil
+}
+
+// Clock is the time as HH:MM rounded to the minute, as the text of the
+// Directions API is, empty if the time is unknown.
+func (t TransitTime) Clock() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Round(time.Minute).Format("15:04")
+}
+
+// SetTimezone saves Timezone as the time zone of the user.
+func (m *MapTool) SetTimezone() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	if _, err := time.LoadLocation(m.Timezone); err != nil || m.Timezone == "" {
+		textResp.Content = fmt.Sprintf("不支持的时区%s，例如：Asia/Shanghai", m.Timezone)
+		return textResp
+	}
+	if err := SetUserConfig(m.UserID, "timezone", m.Timezone); err != nil {
+		textResp.Content = fmt.Sprintf("设置时区失败。")
+		return textResp
+	}
+	beego.Info("Set user time zone:", m.UserID, m.Timezone)
+	textResp.Content = fmt.Sprintf("时区已设置为%s。", m.Timezone)
+	return textResp
+}
+
+// location returns the time zone of the user, else the timezone of the
+// config, else DefaultTimezone.
+func (m *MapTool) location() (*time.Location, error) {
+	name := GetUserConfig(m.UserID, "timezone")
+	if name == "" {
+		name = beego.AppConfig.DefaultString("timezone", DefaultTimezone)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("不支持的时区%s。", name)
+	}
+	return loc, nil
+}
+
+// tripTime turns Depart or Arrive into the parameter of the directions
+// endpoint and a line telling the user the time planned for. Both are empty
+// when the user plans for now.
+func (m *MapTool) tripTime(mode string) (url.Values, string, error) {
+	params := url.Values{}
+	if m.Depart == "" && m.Arrive == "" {
+		return params, "", nil
+	}
+	if m.Depart != "" && m.Arrive != "" {
+		return nil, "", ErrTripTimeConflict
+	}
+	if m.Arrive != "" && mode != MapModeTransit {
+		return nil, "", ErrArriveNotTransit
+	}
+	loc, err := m.location()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().In(loc)
+
+	key, name, value := "departure_time", "出发", m.Depart
+	if m.Arrive != "" {
+		key, name, value = "arrival_time", "到达", m.Arrive
+	}
+	t, err := parseTripTime(value, now)
+	if err != nil {
+		return nil, "", err
+	}
+	params.Set(key, strconv.FormatInt(t.Unix(), 10))
+	return params, fmt.Sprintf("%s时间：%s\n", name, tripDate(t, now)), nil
+}
+
+// parseTripTime parses [DATE] HH:MM in the time zone of now. DATE is today,
+// tomorrow, 今天, 明天, 后天, MM-DD or YYYY-MM-DD. A time without a date that
+// has passed today is taken as tomorrow.
+func parseTripTime(value string, now time.Time) (time.Time, error) {
+	invalid := fmt.Errorf("无法识别时间%s，格式为[日期] HH:MM，例如08:30、tomorrow 08:30、2006-01-02 08:30。", value)
+	fields := strings.Fields(value)
+	if len(fields) < 1 || len(fields) > 2 {
+		return time.Time{}, invalid
+	}
+	clock, err := time.Parse("15:04", fields[len(fields)-1])
+	if err != nil {
+		return time.Time{}, invalid
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if len(fields) == 1 {
+		if t.Before(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+
+	switch date := fields[0]; date {
+	case "today", "今天":
+	case "tomorrow", "明天":
+		t = t.AddDate(0, 0, 1)
+	case "后天":
+		t = t.AddDate(0, 0, 2)
+	default:
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			day, err = time.Parse("01-02", date)
+			if err != nil {
+				return time.Time{}, invalid
+			}
+			day = day.AddDate(now.Year()-day.Year(), 0, 0)
+		}
+		t = time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	}
+	if t.Before(now.Truncate(time.Minute)) {
+		return time.Time{}, fmt.Errorf("时间%s已经过去。", value)
+	}
+	return t, nil
+}
+
+// tripDate formats t relative to now, e.g. 明天 08:30.
+func tripDate(t time.Time, now time.Time) string {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch days := int(t.Sub(today).Hours() / 24); days {
+	case 0:
+		return "今天 " + t.Format("15:04")
+	case 1:
+		return "明天 " + t.Format("15:04")
+	case 2:
+		return "后天 " + t.Format("15:04")
+	}
+	return t.Format("2006-01-02 15:04")
+}
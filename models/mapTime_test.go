@@ -0,0 +1,113 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseTripTime(t *testing.T) {
+	Convey("Subject: Parse departure and arrival times\n", t, func() {
+		loc := time.FixedZone("CST", 8*3600)
+		now := time.Date(2026, 10, 19, 9, 0, 0, 0, loc)
+
+		Convey("A clock is today in the time zone of the user", func() {
+			at, err := parseTripTime("18:00", now)
+			So(err, ShouldBeNil)
+			So(at, ShouldResemble, time.Date(2026, 10, 19, 18, 0, 0, 0, loc))
+			So(tripDate(at, now), ShouldEqual, "今天 18:00")
+		})
+
+		Convey("A clock that has passed today is tomorrow", func() {
+			at, err := parseTripTime("8:30", now)
+			So(err, ShouldBeNil)
+			So(at, ShouldResemble, time.Date(2026, 10, 20, 8, 30, 0, 0, loc))
+		})
+
+		Convey("Dates are relative or absolute", func() {
+			at, err := parseTripTime("tomorrow 08:30", now)
+			So(err, ShouldBeNil)
+			So(tripDate(at, now), ShouldEqual, "明天 08:30")
+			at, err = parseTripTime("后天 07:00", now)
+			So(err, ShouldBeNil)
+			So(at, ShouldResemble, time.Date(2026, 10, 21, 7, 0, 0, 0, loc))
+			at, err = parseTripTime("11-02 07:00", now)
+			So(err, ShouldBeNil)
+			So(tripDate(at, now), ShouldEqual, "2026-11-02 07:00")
+			at, err = parseTripTime("2027-01-01 10:00", now)
+			So(err, ShouldBeNil)
+			So(at, ShouldResemble, time.Date(2027, 1, 1, 10, 0, 0, 0, loc))
+		})
+
+		Convey("Past dates and bad formats are rejected", func() {
+			_, err := parseTripTime("today 08:00", now)
+			So(err, ShouldNotBeNil)
+			_, err = parseTripTime("25:00", now)
+			So(err, ShouldNotBeNil)
+			_, err = parseTripTime("someday 08:00", now)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTransitTime(t *testing.T) {
+	Convey("Subject: Decode scheduled times\n", t, func() {
+		var details TransitDetails
+		err := json.Unmarshal([]byte(`{"departure_time": "2026-10-20T08:42:00+08:00",
+			"arrival_time": {"text": "9:05am", "time_zone": "", "value": 1792456680}}`), &details)
+		So(err, ShouldBeNil)
+		So(details.DepartureTime.Clock(), ShouldEqual, "08:42")
+		So(details.ArrivalTime.Unix(), ShouldEqual, 1792456680)
+		So(TransitTime{}.Clock(), ShouldEqual, "")
+
+		// 09:01:58 is 9:02 in the text of the Directions API
+		err = json.Unmarshal([]byte(`{"text": "上午9:02", "time_zone": "Asia/Shanghai", "value": 1792544518}`), &details.ArrivalTime)
+		So(err, ShouldBeNil)
+		So(details.ArrivalTime.Clock(), ShouldEqual, "09:02")
+	})
+}
+
+func TestMapTripTime(t *testing.T) {
+	Convey("Subject: Plan routes for a time\n", t, func() {
+		last, done := newFakeMap(`{"Routes": [{"legs": [{"duration": {"text": "23分钟"}, "distance": {"text": "9 公里"},
+			"departure_time": "2026-10-20T08:35:00+08:00", "arrival_time": "2026-10-20T08:58:00+08:00",
+			"steps": [{"travel_mode": "TRANSIT", "html_instructions": "地铁", "transit_details": {
+				"departure_stop": {"name": "火车东站"}, "arrival_stop": {"name": "武林广场"},
+				"departure_time": "2026-10-20T08:42:00+08:00", "arrival_time": "2026-10-20T08:55:00+08:00",
+				"line": {"short_name": "1号线"}, "num_stops": 6}}]}]}]}`, nil)
+		defer done()
+		var req Request
+		req.FromUserName = "user"
+		var m MapTool
+		m.NewTool(req)
+
+		Convey("The departure time is sent and the stops show their schedule", func() {
+			m.Depart = "tomorrow 08:30"
+			content, err := m.directions("origin", "destination")
+			So(err, ShouldBeNil)
+			departure, _ := strconv.ParseInt(last.Get("departure_time"), 10, 64)
+			So(time.Unix(departure, 0).Sub(time.Now()), ShouldBeGreaterThan, 0)
+			So(last.Get("arrival_time"), ShouldEqual, "")
+			So(content, ShouldStartWith, "出发时间：明天 08:30\n")
+			So(content, ShouldContainSubstring, "08:35出发，08:58到达\n")
+			So(content, ShouldContainSubstring, "◇ 08:42 火车东站\n    地铁 1号线 6站\n")
+			So(content, ShouldContainSubstring, "◇ 08:55 武林广场\n")
+		})
+
+		Convey("Arrival times are transit only", func() {
+			m.Arrive = "18:00"
+			m.Mode = MapModeDriving
+			_, err := m.directions("origin", "destination")
+			So(err, ShouldEqual, ErrArriveNotTransit)
+		})
+
+		Convey("Depart and arrive exclude each other", func() {
+			m.Depart, m.Arrive = "08:30", "18:00"
+			_, err := m.directions("origin", "destination")
+			So(err, ShouldEqual, ErrTripTimeConflict)
+		})
+	})
+}
@@ -1,15 +1,16 @@
 package models
 
 import (
-	"crypto/tls"
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/astaxie/beego"
-	"github.com/astaxie/beego/config"
-	"github.com/astaxie/beego/httplib"
 	"googlemaps.github.io/maps"
 )
 
@@ -18,15 +19,25 @@ const (
 	MapToolName     = "map"
 	MapToolAlias    = "m"
 	MapToolEndpoint = "/map"
+	MapRouteCommand = "route"
+	MapPickCommand  = "pick"
+	MapGoCommand    = "go"
 	MapHelpMsg      = `map is a direction tool.
 
 Usage:
 
 1. 规划交通路线
-	map direct PlaceA to PlaceB
-		
+	map direct PlaceA to PlaceB [--mode MODE] [--depart TIME | --arrive TIME]
+
+	MODE: transit(默认), driving, walking, bicycling
+	TIME: [DATE] HH:MM，DATE为today, tomorrow, 明天, 后天, MM-DD或YYYY-MM-DD，默认出发时间为现在
+	      --arrive只支持transit
+
 Example 
 	map direct 杭州火车东站 to 武林广场
+	map direct 杭州火车东站 to 武林广场 --mode driving
+	map direct 杭州火车东站 to 武林广场 --depart tomorrow 08:30
+	map direct 杭州火车东站 to 武林广场 --arrive 18:00
 
 2. 设置Home地址
 	map set home Place	
@@ -37,9 +48,104 @@ Example
 4. 规划回家交通路线	
 	map go home Place
 	or
-	直接发送位置信息 
+	直接发送位置信息，然后回复home
+
+5. 设置默认出行方式
+	map set mode MODE
+
+6. 查看其他路线
+	route N
+
+7. 设置时区
+	map set timezone TIMEZONE
+
+Example
+	map set timezone Asia/Tokyo
+
+8. 保存、查看、删除地点，规划去往保存地点的路线
+	map save NAME Place
+	map places
+	map delete NAME
+	map go NAME Place
+
+	保存的地点名称可以代替任何地点关键词
+
+Example
+	map save office 西湖区文三路
+	map go office 杭州火车东站
+	map direct home to office
+
+9. 设置默认城市/地区，优先搜索该地区的地点
+	map set region REGION
+
+Example
+	map set region 杭州
+
+10. 地点关键词匹配到多个地点时，选择其中一个
+	pick N
+
+11. 从当前位置出发
+	先发送位置信息，然后回复home、office等保存的地点名称，或go PLACE获取去往任意地点的路线
+
+12. 搜索附近的地点
+	map nearby KEYWORD [RADIUS] [--near PLACE]
+
+	RADIUS: 搜索半径，单位为米，默认1000
+	PLACE: 以保存的地点或地点关键词为中心，默认为最近发送的位置
+
+Example
+	map nearby 咖啡
+	map nearby 咖啡 500 --near office
+
+13. 通勤提醒，在设定的时间推送路线，用时明显多于平时时提醒
+	map watch PLACE HH:MM [DAYS] [--from PLACE] [--mode MODE]
+	map watches
+	map unwatch PLACE|N
+
+	DAYS: daily(默认), weekdays, weekends或mon,wed,fri
+	--from: 出发地点，默认为最近发送的位置
+	同一地点可设置多个时间的提醒，unwatch N删除map watches中的第N个提醒
+
+Example
+	map watch home 18:00 weekdays --from office
+	map watch home 09:00 weekends
+	map unwatch 2
+
+14. 以图文卡片回复路线，包含路线概要、路线地图和在地图应用中打开的链接
+	map set reply STYLE
+	map direct PlaceA to PlaceB --reply STYLE
+
+	STYLE: text(默认), card
+	地图图片不可用时仍以文字回复
 	
 This tool is powered by Google Maps.`
+
+	MapModeTransit   = "transit"
+	MapModeDriving   = "driving"
+	MapModeWalking   = "walking"
+	MapModeBicycling = "bicycling"
+
+	// MapMaxCandidates is the most places shown for an ambiguous keyword
+	MapMaxCandidates = 5
+)
+
+var (
+	ErrPlaceNotFound = errors.New("place not found")
+
+	lastRoutes = struct {
+		sync.Mutex
+		m map[string]plannedRoutes
+	}{m: make(map[string]plannedRoutes)}
+
+	// MapModes are the travel modes of the directions endpoint
+	MapModes = []string{MapModeTransit, MapModeDriving, MapModeWalking, MapModeBicycling}
+
+	mapModeNames = map[string]string{
+		MapModeTransit:   "公交",
+		MapModeDriving:   "驾车",
+		MapModeWalking:   "步行",
+		MapModeBicycling: "骑行",
+	}
 )
 
 type MapTool struct {
@@ -49,7 +155,35 @@ type MapTool struct {
 	UserID      string
 	HomeAddress string
 	Latlng      string
+	PlaceName   string
+	Place       string
+	Near        string
+	Radius      int
+	Region      string
+	Choice      int
+	Mode        string
+	Depart      string
+	Arrive      string
+	Days        string
+	Timezone    string
+	Route       int
+	Reply       string
 	HelpMsg     string
+
+	// places picked by the user for ambiguous keywords
+	picks map[string]PlaceCandidate
+	// the routes planned by the request, for the route card
+	planned *plannedRoutes
+}
+
+// plannedRoutes are the last directions of a user, kept for route N.
+type plannedRoutes struct {
+	Routes        []Route
+	Mode          string
+	OriginID      string
+	DestinationID string
+	// TripTime tells the departure or arrival time asked for, if any
+	TripTime string
 }
 
 type Routes struct {
@@ -67,6 +201,13 @@ type Route struct {
 	// (A route with no waypoints will contain exactly one leg within the legs array.)
 	Legs []*Leg `json:"legs"`
 
+	// OverviewPolyline contains an approximate (smoothed) path of the resulting directions.
+	OverviewPolyline maps.Polyline `json:"overview_polyline"`
+
+	// Fare contains the total fare on this route, only returned for transit
+	// routes with fares available for every step.
+	Fare *Fare `json:"fare"`
+
 	// Copyrights contains the copyrights text to be displayed for this route. You must handle
 	// and display this information yourself.
 	Copyrights string `json:"copyrights"`
@@ -76,6 +217,16 @@ type Route struct {
 	Warnings []string `json:"warnings"`
 }
 
+// Fare represents the total fare for a route.
+type Fare struct {
+	// Currency is the ISO 4217 currency code.
+	Currency string `json:"currency"`
+	// Value is the total fare amount, in the currency above.
+	Value float64 `json:"value"`
+	// Text is the total fare amount, formatted in the requested language.
+	Text string `json:"text"`
+}
+
 // Leg represents a single leg of a route.
 type Leg struct {
 	// Steps contains an array of steps denoting information about each separate step of the
@@ -95,6 +246,20 @@ type Leg struct {
 	// EndAddress contains the human-readable address (typically a street address)
 	// reflecting the end location of this leg.
 	EndAddress string `json:"end_address"`
+
+	// StartLocation contains the latitude/longitude coordinates of the origin of this leg.
+	StartLocation maps.LatLng `json:"start_location"`
+
+	// EndLocation contains the latitude/longitude coordinates of the destination of this leg.
+	EndLocation maps.LatLng `json:"end_location"`
+
+	// DepartureTime is the scheduled departure time of the leg, only returned
+	// for transit routes.
+	DepartureTime TransitTime `json:"departure_time"`
+
+	// ArrivalTime is the scheduled arrival time of the leg, only returned for
+	// transit routes.
+	ArrivalTime TransitTime `json:"arrival_time"`
 }
 
 // Step represents a single step of a leg.
@@ -137,6 +302,10 @@ type TransitDetails struct {
 	ArrivalStop TransitStop `json:"arrival_stop"`
 	// DepartureStop contains information about the stop/station for this part of the trip.
 	DepartureStop TransitStop `json:"departure_stop"`
+	// ArrivalTime is the scheduled arrival time at the arrival stop.
+	ArrivalTime TransitTime `json:"arrival_time"`
+	// DepartureTime is the scheduled departure time from the departure stop.
+	DepartureTime TransitTime `json:"departure_time"`
 	// Headsign specifies the direction in which to travel on this line, as it is marked on the vehicle or at the departure stop.
 	Headsign string `json:"headsign"`
 	// Headway specifies the expected number of seconds between departures from the same stop at this time
@@ -175,18 +344,6 @@ type Duration struct {
 	Text  string `json:"text"`
 }
 
-var (
-	userHomeConfig config.Configer
-)
-
-func init() {
-	var err error
-	userHomeConfig, err = config.NewConfig("ini", "conf/userhome.conf")
-	if err != nil {
-		beego.Error("Failed to load userhome.conf file.")
-	}
-}
-
 func (m *MapTool) NewTool(req Request) {
 	m.name = MapToolName
 	m.alias = MapToolAlias
@@ -200,16 +357,21 @@ func (m *MapTool) Directions() TextResponse {
 	var originPlaceID, destinationPlaceID string
 	textResp.MsgType = MsgTypeText
 
-	if m.Origin != "" && m.Destination != "" {
+	if m.Destination != "" && (m.Origin != "" || HasCurrentLocation(m.UserID)) {
 		var err error
-		originPlaceID, _, err = getPlaceID(m.Origin)
+		if m.Origin != "" {
+			originPlaceID, _, err = m.resolvePlace(m.Origin)
+		} else {
+			location, _ := GetCurrentLocation(m.UserID)
+			originPlaceID, err = m.locationPlaceID(location)
+		}
 		if err != nil {
-			textResp.Content = fmt.Sprintf("查找地点失败，请尝试其他地点关键词。")
+			textResp.Content = m.placeErrorReply(err, "查找地点失败，请尝试其他地点关键词。", routeReply((*MapTool).Directions))
 			return textResp
 		}
-		destinationPlaceID, _, err = getPlaceID(m.Destination)
+		destinationPlaceID, _, err = m.resolvePlace(m.Destination)
 		if err != nil {
-			textResp.Content = fmt.Sprintf("查找地点失败，请尝试其他地点关键词。")
+			textResp.Content = m.placeErrorReply(err, "查找地点失败，请尝试其他地点关键词。", routeReply((*MapTool).Directions))
 			return textResp
 		}
 	} else {
@@ -218,9 +380,9 @@ func (m *MapTool) Directions() TextResponse {
 	}
 	//originPlaceID = "ChIJWYij7kicTDQRCp51F2RCKfM"
 	//destinationPlaceID = "ChIJwWnPHVdiSzQRN7O4WYYFC14"
-	directionsStr, err := getDirections(originPlaceID, destinationPlaceID)
+	directionsStr, err := m.directions(originPlaceID, destinationPlaceID)
 	if err != nil {
-		textResp.Content = err.Error()
+		textResp.Content = ErrorMessage(err)
 		return textResp
 	}
 	beego.Info("Directions Info:", directionsStr)
@@ -231,28 +393,19 @@ func (m *MapTool) Directions() TextResponse {
 func (m *MapTool) SetHome() TextResponse {
 	var textResp TextResponse
 	textResp.MsgType = MsgTypeText
-	if userHomeConfig.String(m.UserID+"::id") != "" {
+	if _, ok := GetSavedPlace(m.UserID, MapHomePlace); ok {
 		beego.Info("User ", m.UserID, "address is existed. Overwriting..")
 	}
 
-	homePlaceID, address, err := getPlaceID(m.HomeAddress)
+	home, err := m.resolvePlaceCandidate(m.HomeAddress)
 	if err != nil {
-		textResp.Content = fmt.Sprintf("设置Home地址失败，请尝试其他地址关键词。")
+		textResp.Content = m.placeError(err, "设置Home地址失败，请尝试其他地址关键词。", (*MapTool).SetHome)
 		return textResp
 	}
 	//homePlaceID := "idididdid"
 	//address := "addressaddress"
-	if err := userHomeConfig.Set(m.UserID+"::id", homePlaceID); err != nil {
-		textResp.Content = fmt.Sprintf("设置Home地址失败。")
-		return textResp
-	}
-
-	if err := userHomeConfig.Set(m.UserID+"::address", address); err != nil {
-		textResp.Content = fmt.Sprintf("设置Home地址失败。")
-		return textResp
-	}
-
-	if err := userHomeConfig.SaveConfigFile("conf/userhome.conf"); err != nil {
+	homePlaceID, address := home.PlaceID, home.Address
+	if err := SavePlace(m.UserID, SavedPlace{Name: MapHomePlace, PlaceID: homePlaceID, Address: address, Latlng: home.Latlng}); err != nil {
 		textResp.Content = fmt.Sprintf("设置Home地址失败。")
 		return textResp
 	}
@@ -264,131 +417,372 @@ func (m *MapTool) SetHome() TextResponse {
 func (m *MapTool) GetHome() TextResponse {
 	var textResp TextResponse
 	textResp.MsgType = MsgTypeText
-	homePlaceID := userHomeConfig.String(m.UserID + "::id")
-	address := userHomeConfig.String(m.UserID + "::address")
-
-	if homePlaceID == "" || address == "" {
+	home, ok := GetSavedPlace(m.UserID, MapHomePlace)
+	if !ok {
 		textResp.Content = fmt.Sprintf("用户还未设置Home地址，使用map set home来设置Home地址。")
 		return textResp
 	}
 
-	textResp.Content = fmt.Sprintf("Home地址：%s", address)
-	beego.Info("Get user home address:", m.UserID, homePlaceID, address)
+	textResp.Content = fmt.Sprintf("Home地址：%s", home.Address)
+	beego.Info("Get user home address:", m.UserID, home.PlaceID, home.Address)
 	return textResp
 }
 
 func (m *MapTool) GoHome() TextResponse {
+	m.PlaceName = MapHomePlace
+	return m.GoTo()
+}
+
+// GoTo plans the routes from Origin, or the location the user sent, to the
+// place saved as PlaceName.
+func (m *MapTool) GoTo() TextResponse {
 	var textResp TextResponse
 	var originPlaceID string
 	var err error
 	textResp.MsgType = MsgTypeText
-	homePlaceID := userHomeConfig.String(m.UserID + "::id")
-	address := userHomeConfig.String(m.UserID + "::address")
-
-	if homePlaceID == "" || address == "" {
-		textResp.Content = fmt.Sprintf("用户还未设置Home地址，使用map set home来设置Home地址。")
+	destination, ok := GetSavedPlace(m.UserID, m.PlaceName)
+	if !ok {
+		if m.PlaceName == MapHomePlace {
+			textResp.Content = fmt.Sprintf("用户还未设置Home地址，使用map set home来设置Home地址。")
+		} else {
+			textResp.Content = fmt.Sprintf("没有名为%s的地点，使用map save %s Place来保存地点。", m.PlaceName, m.PlaceName)
+		}
 		return textResp
 	}
-
-	// Use nearby search first if Latlng exists
-	if m.Latlng != "" {
-		originPlaceID, err = getPleaceNearby(m.Origin, m.Latlng)
-		if err != nil {
-			originPlaceID, _, err = getPlaceID(m.Origin)
-		}
+	if m.Origin != "" {
+		originPlaceID, _, err = m.resolvePlace(m.Origin)
+	} else if location, ok := GetCurrentLocation(m.UserID); ok {
+		originPlaceID, err = m.locationPlaceID(location)
 	} else {
-		originPlaceID, _, err = getPlaceID(m.Origin)
+		textResp.Content = fmt.Sprintf("出发地点不能为空，使用map go %s Place，或直接发送位置信息。", destination.Name)
+		return textResp
 	}
 	if err != nil {
-		textResp.Content = fmt.Sprintf("查找地点失败，请尝试其他地点关键词。")
+		textResp.Content = m.placeErrorReply(err, "查找地点失败，请尝试其他地点关键词。", routeReply((*MapTool).GoTo))
 		return textResp
 	}
 
-	directionsStr, err := getDirections(originPlaceID, homePlaceID)
+	directionsStr, err := m.directions(originPlaceID, destination.PlaceID)
 	if err != nil {
-		textResp.Content = err.Error()
+		textResp.Content = ErrorMessage(err)
 		return textResp
 	}
 	textResp.Content = directionsStr
 	return textResp
 }
 
-func getPlaceID(keyword string) (placeID string, address string, err error) {
+// SetMode saves Mode as the default travel mode of the user.
+func (m *MapTool) SetMode() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+	if !validMapMode(m.Mode) {
+		textResp.Content = fmt.Sprintf("不支持的出行方式%s，可选：%s", m.Mode, strings.Join(MapModes, ", "))
+		return textResp
+	}
+	if err := SetUserConfig(m.UserID, "mapmode", m.Mode); err != nil {
+		textResp.Content = fmt.Sprintf("设置默认出行方式失败。")
+		return textResp
+	}
+	beego.Info("Set user default travel mode:", m.UserID, m.Mode)
+	textResp.Content = fmt.Sprintf("默认出行方式已设置为%s。", mapModeNames[m.Mode])
+	return textResp
+}
+
+// travelMode returns Mode, else the default mode of the user, else transit.
+func (m *MapTool) travelMode() (string, error) {
+	mode := m.Mode
+	if mode == "" {
+		mode = GetUserConfig(m.UserID, "mapmode")
+	}
+	if mode == "" {
+		return MapModeTransit, nil
+	}
+	if !validMapMode(mode) {
+		return "", fmt.Errorf("不支持的出行方式%s，可选：%s", mode, strings.Join(MapModes, ", "))
+	}
+	return mode, nil
+}
+
+func validMapMode(mode string) bool {
+	for _, m := range MapModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// placeErrorMessage explains a backend failure, else a place lookup that
+// found nothing.
+func placeErrorMessage(err error, notFound string) string {
+	if msg := BackendErrorMessage(err); msg != "" {
+		return msg
+	}
+	return notFound
+}
+
+// getPlaceID returns the place the keyword means, biased to the city region
+// if not empty. Places of the same name in different localities are an
+// *AmbiguousPlaceError, else the best match is taken.
+func getPlaceID(ctx context.Context, keyword string, region string) (placeID string, address string, err error) {
+	place, err := searchPlace(ctx, keyword, region)
+	return place.PlaceID, place.Address, err
+}
+
+// searchPlace is getPlaceID returning the location of the place too.
+func searchPlace(ctx context.Context, keyword string, region string) (PlaceCandidate, error) {
 	var placeSearchResult *maps.PlacesSearchResponse
-	req := httplib.Get(APIADDRESS + APIVERSION + MapToolEndpoint + "/place/search")
-	req.Param("keyword", keyword)
-	req.SetBasicAuth(apiuser, apipassword)
-	req.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-	err = req.ToJSON(&placeSearchResult)
+	env, err := DefaultEnvironment()
 	if err != nil {
-		return "", "", err
+		return PlaceCandidate{}, err
 	}
-	if len(placeSearchResult.Results) < 1 {
-		return "", "", errors.New("place not found")
+	params := url.Values{"keyword": {regionKeyword(keyword, region)}}
+	err = env.Client.WithContext(ctx).CachedGet(placeCache, MapToolEndpoint+"/place/search", params, &placeSearchResult)
+	if err != nil {
+		return PlaceCandidate{}, err
 	}
-	beego.Info("Place Search Result: name:", placeSearchResult.Results[0].Name, "address:", placeSearchResult.Results[0].FormattedAddress, "PlaceID:", placeSearchResult.Results[0].PlaceID)
-	placeID = placeSearchResult.Results[0].PlaceID
-	address = placeSearchResult.Results[0].FormattedAddress
-	return placeID, address, nil
+	results := competingPlaces(keyword, placeSearchResult.Results)
+	if len(results) < 1 {
+		return PlaceCandidate{}, ErrPlaceNotFound
+	}
+	if len(results) > 1 {
+		ambiguous := &AmbiguousPlaceError{Keyword: keyword}
+		for i := 0; i < len(results) && i < MapMaxCandidates; i++ {
+			ambiguous.Candidates = append(ambiguous.Candidates, placeCandidate(results[i]))
+		}
+		beego.Info("Place Search Result is ambiguous:", keyword, "results:", len(results))
+		return PlaceCandidate{}, ambiguous
+	}
+	beego.Info("Place Search Result: name:", results[0].Name, "address:", results[0].FormattedAddress, "PlaceID:", results[0].PlaceID)
+	return placeCandidate(results[0]), nil
 }
 
-func getPleaceNearby(keyword, latlng string) (placeID string, err error) {
-	var placeSearchResult *maps.PlacesSearchResponse
-	req := httplib.Get(APIADDRESS + APIVERSION + MapToolEndpoint + "/place/nearby")
-	req.Param("keyword", keyword)
-	req.Param("latlng", latlng)
-	req.SetBasicAuth(apiuser, apipassword)
-	req.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-	err = req.ToJSON(&placeSearchResult)
+// regionKeyword adds the city region to the keyword, which biases the text
+// search to the city. The region param of the Places API only takes country
+// codes.
+func regionKeyword(keyword string, region string) string {
+	if region == "" || strings.Contains(keyword, region) {
+		return keyword
+	}
+	return region + " " + keyword
+}
+
+// competingPlaces returns the results the keyword could equally mean: the
+// places named exactly the keyword, else the places named as the best match,
+// one per address. A single one is the place to take.
+func competingPlaces(keyword string, results []maps.PlacesSearchResult) []maps.PlacesSearchResult {
+	if len(results) < 1 {
+		return nil
+	}
+	name := results[0].Name
+	for _, result := range results {
+		if result.Name == keyword {
+			name = keyword
+			break
+		}
+	}
+	var competing []maps.PlacesSearchResult
+	addresses := make(map[string]bool)
+	for _, result := range results {
+		if result.Name != name || addresses[result.FormattedAddress] {
+			continue
+		}
+		addresses[result.FormattedAddress] = true
+		competing = append(competing, result)
+	}
+	return competing
+}
+
+func placeCandidate(result maps.PlacesSearchResult) PlaceCandidate {
+	candidate := PlaceCandidate{Name: result.Name, PlaceID: result.PlaceID, Address: result.FormattedAddress}
+	if result.Geometry.Location != (maps.LatLng{}) {
+		candidate.Latlng = result.Geometry.Location.String()
+	}
+	return candidate
+}
+
+func getPleaceNearby(ctx context.Context, keyword, latlng string) (placeID string, err error) {
+	results, err := getPlacesNearby(ctx, keyword, latlng, 0)
 	if err != nil {
 		return "", err
 	}
+	beego.Info("Nearby Place Search Result: name:", results[0].Name, "address:", results[0].FormattedAddress, "PlaceID:", results[0].PlaceID)
+	placeID = results[0].PlaceID
+	return placeID, nil
+}
+
+// getPlacesNearby returns the places around latlng matching the keyword,
+// nearest first. radius in meters limits the search if not zero.
+func getPlacesNearby(ctx context.Context, keyword, latlng string, radius int) ([]maps.PlacesSearchResult, error) {
+	var placeSearchResult *maps.PlacesSearchResponse
+	env, err := DefaultEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{"keyword": {keyword}, "latlng": {latlng}}
+	if radius > 0 {
+		params.Set("radius", strconv.Itoa(radius))
+	}
+	err = env.Client.WithContext(ctx).CachedGet(nearbyCache, MapToolEndpoint+"/place/nearby", params, &placeSearchResult)
+	if err != nil {
+		return nil, err
+	}
 	if len(placeSearchResult.Results) < 1 {
-		return "", errors.New("place not found")
+		return nil, ErrPlaceNotFound
 	}
-	beego.Info("Nearby Place Search Result: name:", placeSearchResult.Results[0].Name, "address:", placeSearchResult.Results[0].FormattedAddress, "PlaceID:", placeSearchResult.Results[0].PlaceID)
-	placeID = placeSearchResult.Results[0].PlaceID
-	return placeID, nil
+	return placeSearchResult.Results, nil
 }
 
-func getDirections(originID string, destinationID string) (string, error) {
+// getDirections plans the routes from originID to destinationID by mode,
+// one of MapModes, alternatives included. params may hold departure_time or
+// arrival_time.
+func getDirections(ctx context.Context, originID string, destinationID string, mode string, params url.Values) ([]Route, error) {
 	var response *Routes
-	var resultStr string
-	req := httplib.Get(APIADDRESS + APIVERSION + MapToolEndpoint + "/direct/transit")
-	req.Param("origin", originID)
-	req.Param("destination", destinationID)
-	req.SetBasicAuth(apiuser, apipassword)
-	req.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-	err := req.ToJSON(&response)
+	env, err := DefaultEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	params.Set("origin", originID)
+	params.Set("destination", destinationID)
+	params.Set("alternatives", "true")
+	err = env.Client.WithContext(ctx).CachedGet(directionsCache, MapToolEndpoint+"/direct/"+mode, params, &response)
 	if err != nil {
 		beego.Trace("error:", err.Error())
+		return nil, err
+	}
+
+	var routes []Route
+	for _, route := range response.Routes {
+		if len(route.Legs) > 0 {
+			routes = append(routes, route)
+		}
+	}
+	if len(routes) < 1 {
+		return nil, errors.New("查询线路失败，无可用线路。")
+	}
+	return routes, nil
+}
+
+// directions plans the routes by the travel mode of the user and keeps them
+// for route N. The reply compares the routes and details the first one.
+func (m *MapTool) directions(originID string, destinationID string) (string, error) {
+	mode, err := m.travelMode()
+	if err != nil {
+		return "", err
+	}
+	params, planned, err := m.tripTime(mode)
+	if err != nil {
 		return "", err
 	}
+	routes, err := getDirections(m.Context(), originID, destinationID, mode, params)
+	if err != nil {
+		return "", err
+	}
+	m.planned = &plannedRoutes{Routes: routes, Mode: mode, OriginID: originID, DestinationID: destinationID, TripTime: planned}
+	lastRoutes.Lock()
+	lastRoutes.m[m.UserID] = *m.planned
+	lastRoutes.Unlock()
+
+	if len(routes) == 1 {
+		return planned + formatRoute(routes[0], mode), nil
+	}
+	content := planned + fmt.Sprintf("共有%d条路线：\n", len(routes))
+	for i, route := range routes {
+		content += fmt.Sprintf("%d. %s\n", i+1, routeSummary(route, mode))
+	}
+	content += fmt.Sprintf("回复route N查看其他路线。\n\n路线1：\n")
+	return content + formatRoute(routes[0], mode), nil
+}
+
+// ShowRoute details route number Route of the last directions of the user.
+func (m *MapTool) ShowRoute() TextResponse {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+
+	lastRoutes.Lock()
+	planned, ok := lastRoutes.m[m.UserID]
+	lastRoutes.Unlock()
+	if !ok {
+		textResp.Content = fmt.Sprintf("没有可以查看的路线，请先使用map direct规划路线。")
+		return textResp
+	}
+	if m.Route < 1 || m.Route > len(planned.Routes) {
+		textResp.Content = fmt.Sprintf("路线编号应为1到%d。", len(planned.Routes))
+		return textResp
+	}
+	textResp.Content = fmt.Sprintf("路线%d：\n", m.Route) + formatRoute(planned.Routes[m.Route-1], planned.Mode)
+	return textResp
+}
 
-	if len(response.Routes) < 1 {
-		return "", errors.New("查询线路失败，无可用线路。")
-	}
-	directionsResult := response.Routes
-	if len(directionsResult[0].Legs) >= 1 {
-		resultStr = fmt.Sprintf("路线总长%s，预计用时%s\n◇ %s\n", directionsResult[0].Legs[0].Distance.HumanReadable,
-			directionsResult[0].Legs[0].Duration.Text,
-			directionsResult[0].Legs[0].StartAddress)
-		for i := 0; i < len(directionsResult[0].Legs[0].Steps); i++ {
-			if directionsResult[0].Legs[0].Steps[i].TravelMode == "WALKING" {
-				resultStr += fmt.Sprintf("    %s\n", directionsResult[0].Legs[0].Steps[i].HTMLInstructions)
-				resultStr += fmt.Sprintf("    %s %s\n", directionsResult[0].Legs[0].Steps[i].Distance.HumanReadable, directionsResult[0].Legs[0].Steps[i].Duration.Text)
-			} else if directionsResult[0].Legs[0].Steps[i].TravelMode == "TRANSIT" {
-				resultStr += fmt.Sprintf("◇ %s\n", directionsResult[0].Legs[0].Steps[i].TransitDetails.DepartureStop.Name)
-				resultStr += fmt.Sprintf("    %s %s %d站\n", directionsResult[0].Legs[0].Steps[i].HTMLInstructions,
-					directionsResult[0].Legs[0].Steps[i].TransitDetails.Line.ShortName,
-					directionsResult[0].Legs[0].Steps[i].TransitDetails.NumStops)
-				resultStr += fmt.Sprintf("    %s %s\n", directionsResult[0].Legs[0].Steps[i].Distance.HumanReadable, directionsResult[0].Legs[0].Steps[i].Duration.Text)
-				resultStr += fmt.Sprintf("◇ %s\n", directionsResult[0].Legs[0].Steps[i].TransitDetails.ArrivalStop.Name)
+// routeSummary is a line comparing the route with its alternatives.
+func routeSummary(route Route, mode string) string {
+	leg := route.Legs[0]
+	summary := fmt.Sprintf("%s，%s", leg.Duration.Text, leg.Distance.HumanReadable)
+	if leg.DepartureTime.Clock() != "" && leg.ArrivalTime.Clock() != "" {
+		summary = fmt.Sprintf("%s-%s，", leg.DepartureTime.Clock(), leg.ArrivalTime.Clock()) + summary
+	}
+	if mode == MapModeTransit {
+		transits, walking := 0, 0
+		for _, step := range leg.Steps {
+			switch step.TravelMode {
+			case "TRANSIT":
+				transits++
+			case "WALKING":
+				walking += step.Distance.Meters
 			}
 		}
-		resultStr += fmt.Sprintf("◇ %s\n", directionsResult[0].Legs[0].EndAddress)
-	} else {
-		return "", errors.New("查询线路失败，无可用线路。")
+		if transits > 1 {
+			summary += fmt.Sprintf("，换乘%d次", transits-1)
+		} else {
+			summary += "，无需换乘"
+		}
+		summary += fmt.Sprintf("，步行%d米", walking)
+	} else if route.Summary != "" {
+		summary += "，途经" + route.Summary
+	}
+	if route.Fare != nil && route.Fare.Text != "" {
+		summary += "，票价" + route.Fare.Text
+	}
+	return summary
+}
+
+func formatRoute(route Route, mode string) string {
+	leg := route.Legs[0]
+	resultStr := fmt.Sprintf("%s路线总长%s，预计用时%s\n", mapModeNames[mode], leg.Distance.HumanReadable,
+		leg.Duration.Text)
+	if leg.DepartureTime.Clock() != "" && leg.ArrivalTime.Clock() != "" {
+		resultStr += fmt.Sprintf("%s出发，%s到达\n", leg.DepartureTime.Clock(), leg.ArrivalTime.Clock())
+	}
+	resultStr += fmt.Sprintf("◇ %s\n", leg.StartAddress)
+	for i, step := range leg.Steps {
+		switch step.TravelMode {
+		case "TRANSIT":
+			resultStr += fmt.Sprintf("◇ %s\n", stopName(step.TransitDetails.DepartureStop, step.TransitDetails.DepartureTime))
+			resultStr += fmt.Sprintf("    %s %s %d站\n", indentLines(plainInstructions(step.HTMLInstructions), "    "),
+				step.TransitDetails.Line.ShortName, step.TransitDetails.NumStops)
+			resultStr += fmt.Sprintf("    %s %s\n", step.Distance.HumanReadable, step.Duration.Text)
+			resultStr += fmt.Sprintf("◇ %s\n", stopName(step.TransitDetails.ArrivalStop, step.TransitDetails.ArrivalTime))
+		case "WALKING":
+			if mode == MapModeTransit {
+				resultStr += fmt.Sprintf("    %s\n", indentLines(plainInstructions(step.HTMLInstructions), "    "))
+				resultStr += fmt.Sprintf("    %s %s\n", step.Distance.HumanReadable, step.Duration.Text)
+				break
+			}
+			fallthrough
+		default:
+			// turn by turn
+			resultStr += fmt.Sprintf("%d. %s\n", i+1, indentLines(plainInstructions(step.HTMLInstructions), "    "))
+			resultStr += fmt.Sprintf("    %s %s\n", step.Distance.HumanReadable, step.Duration.Text)
+		}
+	}
+	resultStr += fmt.Sprintf("◇ %s\n", leg.EndAddress)
+	return resultStr
+}
+
+// stopName is the name of a transit stop, after its scheduled time if known.
+func stopName(stop TransitStop, at TransitTime) string {
+	if at.Clock() == "" {
+		return stop.Name
 	}
-	return resultStr, nil
+	return at.Clock() + " " + stop.Name
 }
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/astaxie/beego"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFormatRoute(t *testing.T) {
+	Convey("Subject: Format the steps of a route\n", t, func() {
+		leg := &Leg{
+			Distance:     Distance{HumanReadable: "5.2 公里"},
+			Duration:     Duration{Text: "12分钟"},
+			StartAddress: "杭州东站",
+			EndAddress:   "武林广场",
+		}
+		route := Route{Legs: []*Leg{leg}}
+
+		Convey("Driving steps are turn by turn", func() {
+			leg.Steps = []*Step{
+				{HTMLInstructions: "向东出发", Distance: Distance{HumanReadable: "200 米"}, Duration: Duration{Text: "1分钟"}, TravelMode: "DRIVING"},
+				{HTMLInstructions: "左转进入环城北路", Distance: Distance{HumanReadable: "5 公里"}, Duration: Duration{Text: "11分钟"}, TravelMode: "DRIVING"},
+			}
+			content := formatRoute(route, MapModeDriving)
+			So(content, ShouldStartWith, "驾车路线总长5.2 公里，预计用时12分钟\n◇ 杭州东站\n")
+			So(content, ShouldContainSubstring, "2. 左转进入环城北路\n    5 公里 11分钟\n")
+			So(content, ShouldEndWith, "◇ 武林广场\n")
+		})
+
+		Convey("Transit steps show the stops", func() {
+			leg.Steps = []*Step{
+				{HTMLInstructions: "步行至火车东站", Distance: Distance{HumanReadable: "300 米"}, Duration: Duration{Text: "4分钟"}, TravelMode: "WALKING"},
+				{HTMLInstructions: "地铁", TravelMode: "TRANSIT", TransitDetails: &TransitDetails{
+					DepartureStop: TransitStop{Name: "火车东站"},
+					ArrivalStop:   TransitStop{Name: "武林广场"},
+					Line:          TransitLine{ShortName: "1号线"},
+					NumStops:      6,
+				}},
+			}
+			content := formatRoute(route, MapModeTransit)
+			So(content, ShouldContainSubstring, "    步行至火车东站\n")
+			So(content, ShouldContainSubstring, "◇ 火车东站\n    地铁 1号线 6站\n")
+		})
+	})
+}
+
+// newFakeMap serves the map endpoints of the ops api and records the query of
+// the last request. places are the place search results by keyword, and the
+// nearby search results by "nearby:" and the keyword.
+func newFakeMap(directions string, places map[string]string) (*url.Values, func()) {
+	var last url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r.URL.Query()
+		if strings.HasPrefix(r.URL.Path, "/v1/map/direct/") {
+			last.Set("mode", strings.TrimPrefix(r.URL.Path, "/v1/map/direct/"))
+			w.Write([]byte(directions))
+			return
+		}
+		if r.URL.Path == "/v1/map/place/search" || r.URL.Path == "/v1/map/place/nearby" {
+			key := r.URL.Query().Get("keyword")
+			if r.URL.Path == "/v1/map/place/nearby" {
+				key = "nearby:" + key
+			}
+			if results, ok := places[key]; ok {
+				w.Write([]byte(`{"results": ` + results + `}`))
+			} else {
+				w.Write([]byte(`{"results": []}`))
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	old := beego.AppConfig.String("apiaddress")
+	beego.AppConfig.Set("apiaddress", server.URL+"/")
+	return &last, func() {
+		beego.AppConfig.Set("apiaddress", old)
+		server.Close()
+	}
+}
+
+const alternativeRoutes = `{"Routes": [
+	{"fare": {"text": "¥4"}, "legs": [{"duration": {"text": "40分钟"}, "distance": {"text": "9 公里"}, "steps": [
+		{"travel_mode": "WALKING", "distance": {"value": 300}},
+		{"travel_mode": "TRANSIT", "html_instructions": "地铁", "transit_details": {"line": {"short_name": "1号线"}}},
+		{"travel_mode": "WALKING", "distance": {"value": 200}}]}]},
+	{"legs": [{"duration": {"text": "55分钟"}, "distance": {"text": "10 公里"}, "steps": [
+		{"travel_mode": "TRANSIT", "html_instructions": "公交", "transit_details": {"line": {"short_name": "K7"}}},
+		{"travel_mode": "TRANSIT", "html_instructions": "公交", "transit_details": {"line": {"short_name": "B1"}}}]}]}
+]}`
+
+func TestMapRoutes(t *testing.T) {
+	Convey("Subject: Alternative routes\n", t, func() {
+		last, done := newFakeMap(alternativeRoutes, nil)
+		defer done()
+		var req Request
+		req.FromUserName = "user"
+		var m MapTool
+		m.NewTool(req)
+
+		content, err := m.directions("origin", "destination")
+		So(err, ShouldBeNil)
+		So(last.Get("alternatives"), ShouldEqual, "true")
+		So(last.Get("mode"), ShouldEqual, MapModeTransit)
+		So(content, ShouldStartWith, "共有2条路线：\n1. 40分钟，9 公里，无需换乘，步行500米，票价¥4\n2. 55分钟，10 公里，换乘1次，步行0米\n")
+		So(content, ShouldContainSubstring, "1号线")
+
+		Convey("route N details another route", func() {
+			m.Route = 2
+			resp := m.ShowRoute()
+			So(resp.Content, ShouldStartWith, "路线2：\n")
+			So(resp.Content, ShouldContainSubstring, "K7")
+		})
+
+		Convey("Route numbers out of range are reported", func() {
+			m.Route = 3
+			So(m.ShowRoute().Content, ShouldEqual, "路线编号应为1到2。")
+		})
+	})
+}
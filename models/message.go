@@ -2,7 +2,9 @@ package models
 
 import (
 	"encoding/xml"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -17,6 +19,9 @@ const (
 	MsgTypeEvent            = "event"
 	MsgTypeEventSubscribe   = "subscribe"
 	MsgTeypEventUnsubscribe = "unsubscribe"
+
+	// WeChatTextLimit is the max size in bytes of the content of a text message
+	WeChatTextLimit = 2048
 )
 
 type msgBaseReq struct {
@@ -103,3 +108,34 @@ type Item struct {
 	PicUrl      string
 	Url         string
 }
+
+// SplitText splits content into parts no longer than limit bytes, breaking at
+// line ends whenever possible.
+func SplitText(content string, limit int) []string {
+	var parts []string
+	var part string
+	for _, line := range strings.SplitAfter(content, "\n") {
+		for len(line) > limit {
+			// a single line longer than the limit is cut at a rune boundary
+			cut := limit
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if part != "" {
+				parts = append(parts, part)
+				part = ""
+			}
+			parts = append(parts, line[:cut])
+			line = line[cut:]
+		}
+		if len(part)+len(line) > limit {
+			parts = append(parts, part)
+			part = ""
+		}
+		part += line
+	}
+	if part != "" || len(parts) == 0 {
+		parts = append(parts, part)
+	}
+	return parts
+}
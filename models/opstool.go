@@ -1,6 +1,6 @@
 package models
 
-import "github.com/astaxie/beego"
+import "context"
 
 type Tool interface {
 	NewTool()
@@ -10,6 +10,21 @@ type toolBase struct {
 	name     string
 	alias    string
 	endpoint string
+	// ctx is the context of the request the tool runs for
+	ctx context.Context
+}
+
+// SetContext makes the backend calls of the tool stop when ctx is done.
+func (t *toolBase) SetContext(ctx context.Context) {
+	t.ctx = ctx
+}
+
+// Context is the context of the request, background if none is set.
+func (t *toolBase) Context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
 }
 
 const (
@@ -17,8 +32,3 @@ const (
 	//APIADDRESS = "http://11.11.1.6:8098/"
 	APIVERSION = "v1"
 )
-
-var (
-	apiuser     = beego.AppConfig.String("apiuser")
-	apipassword = beego.AppConfig.String("apipassword")
-)
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/httplib"
+)
+
+const (
+	WeChatAPIAddress = "https://api.weixin.qq.com/cgi-bin"
+
+	// errcodes telling the access token is invalid or expired
+	weChatErrInvalidToken = 40001
+	weChatErrExpiredToken = 42001
+	// errcode telling the user sent no message in the last 48 hours, out of
+	// the time customer service messages may be pushed
+	weChatErrResponseOutOfTime = 45015
+)
+
+var (
+	// ErrPushOutOfTime is returned when the user sent no message in the last
+	// 48 hours, so no message can be pushed until the user sends one.
+	ErrPushOutOfTime = errors.New("push message failed, the user sent no message in 48 hours")
+
+	appID     = beego.AppConfig.String("appid")
+	appSecret = beego.AppConfig.String("appsecret")
+
+	accessToken struct {
+		sync.Mutex
+		token   string
+		expires time.Time
+	}
+)
+
+type weChatError struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+type accessTokenResponse struct {
+	weChatError
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// customMessage is the body of a customer service message, which lets the
+// bot push a message to a user outside of the passive reply.
+type customMessage struct {
+	ToUser  string      `json:"touser"`
+	MsgType string      `json:"msgtype"`
+	Text    *customText `json:"text,omitempty"`
+	News    *customNews `json:"news,omitempty"`
+}
+
+type customText struct {
+	Content string `json:"content"`
+}
+
+type customNews struct {
+	Articles []customArticle `json:"articles"`
+}
+
+type customArticle struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	URL         string `json:"url"`
+	PicURL      string `json:"picurl"`
+}
+
+// PushText pushes a text message to the user through the customer service
+// message API.
+func PushText(openID string, content string) error {
+	msg := customMessage{ToUser: openID, MsgType: MsgTypeText, Text: &customText{Content: content}}
+	return pushMessage(msg)
+}
+
+// PushNews pushes the articles to the user as a news message.
+func PushNews(openID string, articles []*Item) error {
+	news := &customNews{}
+	for _, article := range articles {
+		news.Articles = append(news.Articles, customArticle{Title: article.Title,
+			Description: article.Description, URL: article.Url, PicURL: article.PicUrl})
+	}
+	return pushMessage(customMessage{ToUser: openID, MsgType: MsgTypeNews, News: news})
+}
+
+func pushMessage(msg customMessage) error {
+	var result weChatError
+	for retry := 0; retry < 2; retry++ {
+		token, err := getAccessToken()
+		if err != nil {
+			return err
+		}
+		req := httplib.Post(WeChatAPIAddress + "/message/custom/send?access_token=" + token)
+		if _, err := req.JSONBody(msg); err != nil {
+			return err
+		}
+		if err := req.ToJSON(&result); err != nil {
+			return err
+		}
+		if result.ErrCode != weChatErrInvalidToken && result.ErrCode != weChatErrExpiredToken {
+			break
+		}
+		beego.Info("WeChat access token is invalid, refreshing.")
+		resetAccessToken()
+	}
+	if result.ErrCode == weChatErrResponseOutOfTime {
+		return ErrPushOutOfTime
+	}
+	if result.ErrCode != 0 {
+		return fmt.Errorf("push message failed, errcode: %d, errmsg: %s", result.ErrCode, result.ErrMsg)
+	}
+	beego.Info("Pushed message to the user. User:", msg.ToUser, "Message Type:", msg.MsgType)
+	return nil
+}
+
+func getAccessToken() (string, error) {
+	accessToken.Lock()
+	defer accessToken.Unlock()
+
+	if accessToken.token != "" && time.Now().Before(accessToken.expires) {
+		return accessToken.token, nil
+	}
+
+	var tokenResp accessTokenResponse
+	req := httplib.Get(WeChatAPIAddress + "/token")
+	req.Param("grant_type", "client_credential")
+	req.Param("appid", appID)
+	req.Param("secret", appSecret)
+	err := req.ToJSON(&tokenResp)
+	if err != nil {
+		return "", err
+	}
+	if tokenResp.ErrCode != 0 || tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("get access token failed, errcode: %d, errmsg: %s", tokenResp.ErrCode, tokenResp.ErrMsg)
+	}
+	accessToken.token = tokenResp.AccessToken
+	// refresh a minute earlier than the token really expires
+	accessToken.expires = time.Now().Add(time.Duration(tokenResp.ExpiresIn-60) * time.Second)
+	return accessToken.token, nil
+}
+
+func resetAccessToken() {
+	accessToken.Lock()
+	accessToken.token = ""
+	accessToken.Unlock()
+}
@@ -0,0 +1,287 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/httplib"
+)
+
+const (
+	// Search Tool
+	SearchToolName  = "search"
+	SearchToolAlias = "s"
+	SearchHelpMsg   = `search looks the key words up with one of the search providers.
+
+Usage:
+	search [--provider PROVIDER] [--n N] [--site SITE] [--lang LANG] KEY
+	search next
+or
+	s KEY
+	s next
+
+KEY:
+	search key words.
+
+Options:
+	--provider  google, bing, duckduckgo or wiki, searchprovider of the config by default
+	--n         number of results, 1 to 8, 4 by default
+	--site      only search the site, e.g. github.com
+	--lang      language of the results, e.g. en, zh-CN
+
+next:
+	the next page of your last search.
+
+Example:
+	search --provider wiki redis failover
+	s --provider duckduckgo --n 8 beego
+	`
+
+	// SearchDefaultN is the number of results of a search without --n
+	SearchDefaultN = 4
+	// SearchMaxN is the most articles a WeChat news message holds
+	SearchMaxN = 8
+)
+
+var (
+	ErrSearchNoQuery  = errors.New("没有可以翻页的搜索，请先搜索关键词。")
+	ErrSearchNoResult = errors.New("没有找到更多结果。")
+
+	searchProviders = make(map[string]SearchProvider)
+
+	// the last search of each user, which search next continues
+	searchQueries = struct {
+		sync.Mutex
+		m map[string]SearchQuery
+	}{m: make(map[string]SearchQuery)}
+
+	// how long the favicons of a search may take before the logo is used
+	faviconTimeout = time.Duration(beego.AppConfig.DefaultInt("favicontimeout", 1500)) * time.Millisecond
+)
+
+// SearchProvider is a search engine the search tool sends queries to.
+type SearchProvider interface {
+	// Search returns up to query.N results starting at query.Start. It stops
+	// when ctx is done.
+	Search(ctx context.Context, query SearchQuery) ([]SearchResult, error)
+	// Logo is the thumbnail of results without an image or favicon.
+	Logo() string
+}
+
+// webSearchProvider is a SearchProvider of the public web. Only its results
+// get the favicons of their sites; the sites of internal providers are never
+// fetched from.
+type webSearchProvider interface {
+	SearchProvider
+	searchesWeb()
+}
+
+type SearchQuery struct {
+	Provider string
+	Key      string
+	N        int
+	Start    int
+	Site     string
+	Lang     string
+}
+
+type SearchResult struct {
+	Title    string
+	Abstract string
+	URL      string
+	Image    string
+}
+
+type SearchTool struct {
+	toolBase
+	UserID   string
+	Provider string
+	Key      string
+	N        int
+	Start    int
+	Site     string
+	Lang     string
+	Next     bool
+	HelpMsg  string
+}
+
+// RegisterSearchProvider makes a provider selectable by name.
+func RegisterSearchProvider(name string, provider SearchProvider) {
+	searchProviders[name] = provider
+}
+
+// SearchProviderNames returns the registered providers.
+func SearchProviderNames() []string {
+	names := make([]string, 0, len(searchProviders))
+	for name := range searchProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *SearchTool) NewTool() {
+	s.name = SearchToolName
+	s.alias = SearchToolAlias
+	s.HelpMsg = SearchHelpMsg
+	s.Provider = beego.AppConfig.DefaultString("searchprovider", GoogleProviderName)
+}
+
+func (s *SearchTool) Run() (NewsResponse, error) {
+	var newsResp NewsResponse
+	newsResp.MsgType = MsgTypeNews
+
+	query := SearchQuery{Provider: s.Provider, Key: s.Key, N: s.N, Start: s.Start, Site: s.Site, Lang: s.Lang}
+	if s.Next {
+		searchQueries.Lock()
+		last, ok := searchQueries.m[s.UserID]
+		searchQueries.Unlock()
+		if !ok {
+			return newsResp, ErrSearchNoQuery
+		}
+		query = last
+		query.Start = last.Start + last.N
+	}
+	if query.N <= 0 {
+		query.N = SearchDefaultN
+	}
+	if query.N > SearchMaxN {
+		query.N = SearchMaxN
+	}
+	provider, ok := searchProviders[query.Provider]
+	if !ok {
+		return newsResp, fmt.Errorf("未知搜索引擎%s，可用搜索引擎：%s", query.Provider,
+			strings.Join(SearchProviderNames(), ", "))
+	}
+
+	results, err := cachedSearch(s.Context(), provider, query)
+	if err != nil {
+		return newsResp, err
+	}
+	if len(results) == 0 {
+		return newsResp, ErrSearchNoResult
+	}
+	if len(results) > query.N {
+		results = results[:query.N]
+	}
+	if s.UserID != "" {
+		searchQueries.Lock()
+		searchQueries.m[s.UserID] = query
+		searchQueries.Unlock()
+	}
+
+	newsResp.ArticleCount = len(results)
+	thumbnails := searchThumbnails(results, provider)
+	for i, result := range results {
+		item := Item{Title: result.Title, Description: result.Abstract, Url: result.URL, PicUrl: thumbnails[i]}
+		newsResp.Articles = append(newsResp.Articles, &item)
+	}
+
+	return newsResp, nil
+}
+
+func cachedSearch(ctx context.Context, provider SearchProvider, query SearchQuery) ([]SearchResult, error) {
+	key := fmt.Sprintf("%s|%s|%d|%d|%s|%s", query.Provider, query.Key, query.N, query.Start, query.Site, query.Lang)
+	if results, ok := searchCache.Get(key); ok {
+		return results.([]SearchResult), nil
+	}
+	results, err := provider.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	// an empty page may be a hiccup of the provider, it is asked again
+	if len(results) > 0 {
+		searchCache.Set(key, results)
+	}
+	return results, nil
+}
+
+// searchThumbnails returns the image of each result, else the favicon of its
+// site for web providers, else the logo of the provider. Favicons not found
+// within faviconTimeout fall back to the logo; their lookups go on in the
+// background to fill the cache.
+func searchThumbnails(results []SearchResult, provider SearchProvider) []string {
+	_, web := provider.(webSearchProvider)
+	logo := provider.Logo()
+	thumbnails := make([]string, len(results))
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for i, result := range results {
+		thumbnails[i] = logo
+		if result.Image != "" {
+			thumbnails[i] = result.Image
+			continue
+		}
+		if !web {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, pageURL string) {
+			defer wg.Done()
+			if favicon := getFavicon(pageURL); favicon != "" {
+				lock.Lock()
+				thumbnails[i] = favicon
+				lock.Unlock()
+			}
+		}(i, result.URL)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(faviconTimeout):
+		beego.Info("Favicon lookup is slow, using the logo.")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	return append([]string(nil), thumbnails...)
+}
+
+// getFavicon returns the favicon url of the site of pageURL, "" if the site
+// has none. Results are cached by host.
+func getFavicon(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if favicon, ok := faviconCache.Get(u.Host); ok {
+		return favicon.(string)
+	}
+
+	favicon := u.Scheme + "://" + u.Host + "/favicon.ico"
+	req := httplib.Get(favicon)
+	req.SetTimeout(faviconTimeout, faviconTimeout)
+	resp, err := req.Response()
+	if err != nil {
+		// not cached, the site may answer next time
+		return ""
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		favicon = ""
+	}
+	faviconCache.Set(u.Host, favicon)
+	return favicon
+}
+
+// searchKey adds the site restriction to the key words for providers without
+// a site parameter.
+func searchKey(query SearchQuery) string {
+	if query.Site == "" {
+		return query.Key
+	}
+	return "site:" + query.Site + " " + query.Key
+}
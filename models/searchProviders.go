@@ -0,0 +1,263 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/astaxie/beego"
+	"golang.org/x/net/html"
+)
+
+const (
+	BingProviderName       = "bing"
+	DuckDuckGoProviderName = "duckduckgo"
+	WikiProviderName       = "wiki"
+
+	BingEndpoint       = "https://api.bing.microsoft.com/v7.0"
+	DuckDuckGoEndpoint = "https://html.duckduckgo.com"
+
+	// runes of a wiki page shown as the abstract
+	wikiAbstractLength = 120
+)
+
+// transports of the search apis by base url
+var searchTransports = struct {
+	sync.Mutex
+	m map[string]*http.Transport
+}{m: make(map[string]*http.Transport)}
+
+func init() {
+	RegisterSearchProvider(BingProviderName, bingProvider{})
+	RegisterSearchProvider(DuckDuckGoProviderName, duckDuckGoProvider{})
+	RegisterSearchProvider(WikiProviderName, wikiProvider{})
+}
+
+// newSearchClient returns a client for a search api outside of the ops api,
+// stopping when ctx is done. The clients of an api share one transport.
+func newSearchClient(ctx context.Context, baseURL string) *APIClient {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	searchTransports.Lock()
+	transport, ok := searchTransports.m[baseURL]
+	if !ok {
+		transport = newAPITransport(nil, DefaultAPITimeout)
+		searchTransports.m[baseURL] = transport
+	}
+	searchTransports.Unlock()
+	return &APIClient{
+		BaseURL:      baseURL,
+		Timeout:      DefaultAPITimeout,
+		Retries:      DefaultAPIRetries,
+		RetryBackoff: DefaultAPIRetryBackoff,
+		Header:       make(http.Header),
+		Breaker:      GetCircuitBreaker(baseURL),
+		Transport:    transport,
+		ctx:          ctx,
+	}
+}
+
+// bingProvider searches with the Bing Web Search API, keyed by bingkey.
+type bingProvider struct{}
+
+type bingResponse struct {
+	WebPages struct {
+		Value []struct {
+			Name    string `json:"name"`
+			URL     string `json:"url"`
+			Snippet string `json:"snippet"`
+		} `json:"value"`
+	} `json:"webPages"`
+}
+
+func (bingProvider) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	key := beego.AppConfig.String("bingkey")
+	if key == "" {
+		return nil, errors.New("没有配置Bing搜索的bingkey。")
+	}
+	client := newSearchClient(ctx, beego.AppConfig.DefaultString("bingendpoint", BingEndpoint))
+	client.Header.Set("Ocp-Apim-Subscription-Key", key)
+
+	params := url.Values{"q": {searchKey(query)}, "count": {strconv.Itoa(query.N)},
+		"offset": {strconv.Itoa(query.Start)}}
+	if query.Lang != "" {
+		params.Set("setLang", query.Lang)
+	}
+	var response bingResponse
+	if err := client.Get("/search", params, &response); err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, page := range response.WebPages.Value {
+		results = append(results, SearchResult{Title: page.Name, Abstract: page.Snippet, URL: page.URL})
+	}
+	return results, nil
+}
+
+func (bingProvider) Logo() string {
+	return "https://www.bing.com/favicon.ico"
+}
+
+func (bingProvider) searchesWeb() {}
+
+// duckDuckGoProvider scrapes the html version of DuckDuckGo, which needs no
+// api key.
+type duckDuckGoProvider struct{}
+
+func (duckDuckGoProvider) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	client := newSearchClient(ctx, beego.AppConfig.DefaultString("duckduckgoendpoint", DuckDuckGoEndpoint))
+	params := url.Values{"q": {searchKey(query)}}
+	if query.Start > 0 {
+		params.Set("s", strconv.Itoa(query.Start))
+	}
+	if query.Lang != "" {
+		params.Set("kl", query.Lang)
+	}
+	var body []byte
+	if err := client.Get("/html/", params, &body); err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, &APIDecodeError{URL: client.BaseURL + "/html/", Err: err}
+	}
+	results := parseDuckDuckGoResults(doc)
+	if len(results) > query.N {
+		results = results[:query.N]
+	}
+	return results, nil
+}
+
+func (duckDuckGoProvider) Logo() string {
+	return "https://duckduckgo.com/favicon.ico"
+}
+
+func (duckDuckGoProvider) searchesWeb() {}
+
+// parseDuckDuckGoResults collects the result__a links and the
+// result__snippet following each of them. Ads are skipped.
+func parseDuckDuckGoResults(doc *html.Node) []SearchResult {
+	var results []SearchResult
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			class := htmlAttr(n, "class")
+			switch {
+			case hasClass(class, "result--ad"):
+				return
+			case n.Data == "a" && hasClass(class, "result__a"):
+				results = append(results, SearchResult{Title: htmlText(n), URL: duckDuckGoTarget(htmlAttr(n, "href"))})
+				return
+			case hasClass(class, "result__snippet") && len(results) > 0:
+				results[len(results)-1].Abstract = htmlText(n)
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return results
+}
+
+// duckDuckGoTarget returns the page a DuckDuckGo redirect link points to,
+// e.g. //duckduckgo.com/l/?uddg=https%3A%2F%2Fgithub.com%2F
+func duckDuckGoTarget(href string) string {
+	if strings.HasPrefix(href, "//") {
+		href = "https:" + href
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	if target := u.Query().Get("uddg"); target != "" {
+		return target
+	}
+	return href
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(class string, name string) bool {
+	for _, c := range strings.Fields(class) {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func htmlText(n *html.Node) string {
+	var buf bytes.Buffer
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// wikiProvider searches the internal wiki indexed in Elasticsearch at
+// wikiaddress. The documents of wikiindex need title, url and content fields.
+type wikiProvider struct{}
+
+type wikiSearchResponse struct {
+	Hits struct {
+		Hits []struct {
+			Source struct {
+				Title   string `json:"title"`
+				URL     string `json:"url"`
+				Content string `json:"content"`
+			} `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func (wikiProvider) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	address := beego.AppConfig.String("wikiaddress")
+	if address == "" {
+		return nil, errors.New("没有配置内部wiki的wikiaddress。")
+	}
+	client := newSearchClient(ctx, address)
+	client.User = beego.AppConfig.String("wikiuser")
+	client.Password = beego.AppConfig.String("wikipassword")
+
+	params := url.Values{"q": {query.Key}, "from": {strconv.Itoa(query.Start)}, "size": {strconv.Itoa(query.N)}}
+	var response wikiSearchResponse
+	index := beego.AppConfig.DefaultString("wikiindex", "wiki")
+	if err := client.Get("/"+index+"/_search", params, &response); err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, hit := range response.Hits.Hits {
+		abstract := []rune(hit.Source.Content)
+		if len(abstract) > wikiAbstractLength {
+			abstract = append(abstract[:wikiAbstractLength], '…')
+		}
+		results = append(results, SearchResult{Title: hit.Source.Title, Abstract: string(abstract), URL: hit.Source.URL})
+	}
+	return results, nil
+}
+
+func (wikiProvider) Logo() string {
+	return beego.AppConfig.String("wikilogo")
+}
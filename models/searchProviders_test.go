@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/astaxie/beego"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/html"
+)
+
+const duckDuckGoPage = `<html><body>
+<div class="result results_links result--ad">
+	<h2 class="result__title"><a class="result__a" href="https://duckduckgo.com/y.js?ad=1">Ad</a></h2>
+	<a class="result__snippet" href="#">Buy now</a>
+</div>
+<div class="result results_links">
+	<h2 class="result__title"><a class="result__a" href="//duckduckgo.com/l/?uddg=https%3A%2F%2Fgithub.com%2Fastaxie%2Fbeego&amp;rut=x">astaxie/<b>beego</b></a></h2>
+	<a class="result__snippet" href="#">beego is an open-source,
+		high-performance web framework</a>
+</div>
+<div class="result results_links">
+	<h2 class="result__title"><a class="result__a" href="https://beego.me/">Beego</a></h2>
+</div>
+</body></html>`
+
+func TestDuckDuckGoResults(t *testing.T) {
+	Convey("Subject: Parse DuckDuckGo html results\n", t, func() {
+		doc, err := html.Parse(strings.NewReader(duckDuckGoPage))
+		So(err, ShouldBeNil)
+		results := parseDuckDuckGoResults(doc)
+		So(len(results), ShouldEqual, 2)
+		So(results[0], ShouldResemble, SearchResult{Title: "astaxie/beego", URL: "https://github.com/astaxie/beego",
+			Abstract: "beego is an open-source, high-performance web framework"})
+		So(results[1].URL, ShouldEqual, "https://beego.me/")
+	})
+}
+
+func TestSearchToolProvider(t *testing.T) {
+	Convey("Subject: Select the search provider\n", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/wiki/_search" || r.URL.Query().Get("q") != "redis failover" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"hits": {"hits": [{"_source": {"title": "Redis failover", "url": "https://wiki/redis", "content": "Promote the replica"}}]}}`))
+		}))
+		defer server.Close()
+		beego.AppConfig.Set("wikiaddress", server.URL)
+		beego.AppConfig.Set("wikilogo", "https://wiki/logo.png")
+		defer beego.AppConfig.Set("wikiaddress", "")
+
+		var s SearchTool
+		s.NewTool()
+		s.Provider = WikiProviderName
+		s.Key = "redis failover"
+
+		Convey("Results of the provider become articles", func() {
+			resp, err := s.Run()
+			So(err, ShouldBeNil)
+			So(resp.ArticleCount, ShouldEqual, 1)
+			So(resp.Articles[0].Title, ShouldEqual, "Redis failover")
+			So(resp.Articles[0].Description, ShouldEqual, "Promote the replica")
+		})
+
+		Convey("Unknown providers are reported", func() {
+			s.Provider = "yahoo"
+			_, err := s.Run()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "duckduckgo")
+		})
+	})
+}
+
+// countingProvider returns its results and counts the searches.
+type countingProvider struct {
+	results  []SearchResult
+	searches *int
+}
+
+func (p countingProvider) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	*p.searches++
+	return p.results, nil
+}
+
+func (countingProvider) Logo() string {
+	return ""
+}
+
+func TestCachedSearch(t *testing.T) {
+	Convey("Subject: Cache the results of a search\n", t, func() {
+		var searches int
+		query := SearchQuery{Provider: "counting", Key: "cached search", N: SearchDefaultN}
+
+		Convey("Results are cached", func() {
+			provider := countingProvider{results: []SearchResult{{Title: "result"}}, searches: &searches}
+			query.Key = "cached search results"
+			cachedSearch(context.Background(), provider, query)
+			results, err := cachedSearch(context.Background(), provider, query)
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 1)
+			So(searches, ShouldEqual, 1)
+		})
+
+		Convey("Empty results are searched again", func() {
+			provider := countingProvider{searches: &searches}
+			query.Key = "cached search nothing"
+			cachedSearch(context.Background(), provider, query)
+			results, err := cachedSearch(context.Background(), provider, query)
+			So(err, ShouldBeNil)
+			So(results, ShouldBeEmpty)
+			So(searches, ShouldEqual, 2)
+		})
+	})
+}
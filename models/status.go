@@ -0,0 +1,64 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Status Tool
+	StatusToolName = "status"
+	StatusHelpMsg  = `status shows the health of the backend APIs and the response caches.
+
+Usage:
+	status`
+)
+
+var breakerStateNames = map[string]string{
+	BreakerClosed:   "正常",
+	BreakerOpen:     "熔断中",
+	BreakerHalfOpen: "恢复探测中",
+}
+
+type StatusTool struct {
+	toolBase
+	HelpMsg string
+}
+
+func (s *StatusTool) NewTool() {
+	s.name = StatusToolName
+	s.HelpMsg = StatusHelpMsg
+}
+
+func (s *StatusTool) Run() (TextResponse, error) {
+	var textResp TextResponse
+	textResp.MsgType = MsgTypeText
+
+	// register the breaker of every environment, even the ones not called yet
+	for _, name := range EnvironmentNames() {
+		GetEnvironment(name)
+	}
+
+	statuses := BreakerStatuses()
+	textResp.Content = fmt.Sprintf("共有%d个后端服务。\n", len(statuses))
+	for i, status := range statuses {
+		textResp.Content += fmt.Sprintf("%d. %s：%s\n", i+1, status.Name, breakerStateNames[status.State])
+		if status.State == BreakerOpen {
+			retry := breakerCooldown - time.Since(status.OpenedAt)
+			if retry < 0 {
+				retry = 0
+			}
+			textResp.Content += fmt.Sprintf("    连续失败%d次，%d秒后重试\n", status.Failures, int(retry/time.Second))
+		}
+		if status.LastError != nil && status.State != BreakerClosed {
+			textResp.Content += fmt.Sprintf("    最近错误：%s\n", ErrorMessage(status.LastError))
+		}
+	}
+
+	textResp.Content += "缓存：\n"
+	for _, stats := range AllCacheStats() {
+		textResp.Content += fmt.Sprintf("    %s：%d条，命中%d次，未命中%d次，淘汰%d次\n", stats.Name, stats.Size,
+			stats.Hits, stats.Misses, stats.Evictions)
+	}
+	return textResp, nil
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/config"
+)
+
+const (
+	UserConfigFile = "conf/userhome.conf"
+)
+
+var (
+	// userHomeConfig keeps the settings of every user in a section named by
+	// the openid of the user.
+	userHomeConfig config.Configer
+	userConfigLock sync.Mutex
+	// userConfigPath is where userHomeConfig is saved
+	userConfigPath = UserConfigFile
+)
+
+func init() {
+	userHomeConfig = loadUserConfig(userConfigPath)
+}
+
+// loadUserConfig returns nil if the file can't be loaded. The ini parser
+// returns a typed nil container on errors, which must not end up in the
+// interface as it would not compare equal to nil.
+func loadUserConfig(path string) config.Configer {
+	conf, err := config.NewConfig("ini", path)
+	if err != nil {
+		beego.Error("Failed to load userhome.conf file.", err)
+		return nil
+	}
+	return conf
+}
+
+// GetUserConfig returns the setting key of the user, empty if not set.
+func GetUserConfig(userID string, key string) string {
+	if userHomeConfig == nil {
+		return ""
+	}
+	return userHomeConfig.String(userID + "::" + key)
+}
+
+// SetUserConfig sets the setting key of the user and saves it to the file.
+func SetUserConfig(userID string, key string, value string) error {
+	return SetUserConfigs(userID, map[string]string{key: value})
+}
+
+// SetUserConfigs sets several settings of the user and saves them to the file
+// at once.
+func SetUserConfigs(userID string, values map[string]string) error {
+	userConfigLock.Lock()
+	defer userConfigLock.Unlock()
+
+	if userHomeConfig == nil {
+		return errors.New("userhome.conf is not loaded")
+	}
+	for key, value := range values {
+		if err := userHomeConfig.Set(userID+"::"+key, value); err != nil {
+			return err
+		}
+	}
+	return userHomeConfig.SaveConfigFile(userConfigPath)
+}
+
+// UserConfigs returns a copy of every setting of the user. Keys are lower
+// case.
+func UserConfigs(userID string) map[string]string {
+	userConfigLock.Lock()
+	defer userConfigLock.Unlock()
+
+	configs := make(map[string]string)
+	if userHomeConfig == nil {
+		return configs
+	}
+	section, err := userHomeConfig.GetSection(strings.ToLower(userID))
+	if err != nil {
+		return configs
+	}
+	for key, value := range section {
+		configs[key] = value
+	}
+	return configs
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMissingUserConfig(t *testing.T) {
+	Convey("Subject: Users settings without userhome.conf\n", t, func() {
+		So(loadUserConfig(filepath.Join("testdata", "no-such-userhome.conf")), ShouldBeNil)
+
+		oldConfig := userHomeConfig
+		defer func() {
+			userHomeConfig = oldConfig
+		}()
+		userHomeConfig = loadUserConfig(filepath.Join("testdata", "no-such-userhome.conf"))
+		So(GetUserConfig("user", "mapmode"), ShouldEqual, "")
+		So(UserConfigs("user"), ShouldBeEmpty)
+		So(SetUserConfig("user", "mapmode", "driving"), ShouldNotBeNil)
+	})
+}
@@ -3,9 +3,11 @@ package routers
 import (
 	"github.com/astaxie/beego"
 	"github.com/xzdbd/ops-angel/controllers"
+	"github.com/xzdbd/ops-angel/models"
 )
 
 func init() {
 	beego.Router("/", &controllers.MainController{})
 	beego.Router("/weixin", &controllers.AngelController{})
+	beego.Router(models.MapImagePath, &controllers.MapImageController{})
 }